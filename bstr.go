@@ -0,0 +1,22 @@
+package ole
+
+import (
+	"unicode/utf16"
+	"unsafe"
+)
+
+// bstrToString decodes BSTR (UTF-16) into the UTF-8 string for Lua.
+// It reads the length stored before the characters instead of looking for
+// NUL, so that the embedded NULs are kept. The surrogate pairs become
+// the characters beyond U+FFFF, and the unpaired ones become U+FFFD.
+func bstrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	// BSTR has the byte length as a 32-bit integer just before p.
+	n := *(*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) - 4)) / 2
+	if n == 0 {
+		return ""
+	}
+	return string(utf16.Decode((*[1 << 29]uint16)(unsafe.Pointer(p))[:n:n]))
+}
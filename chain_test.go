@@ -0,0 +1,101 @@
+package ole
+
+import (
+	"testing"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+// newShell makes the state whose global "shell" is Shell.Application.
+func newShell(t *testing.T) (*lua.LState, *ole.IDispatch) {
+	t.Helper()
+	L := lua.NewState()
+	L.SetGlobal("create_object", L.NewFunction(CreateObject))
+
+	if err := L.DoString(`shell = create_object("Shell.Application")`); err != nil {
+		closeShell(L)
+		t.Fatalf("create_object: %s", err)
+	}
+	ud, ok := L.GetGlobal("shell").(*lua.LUserData)
+	if !ok {
+		closeShell(L)
+		t.Fatal("create_object(\"Shell.Application\") failed")
+	}
+	return L, ud.Value.(*capsuleT).Data
+}
+
+func closeShell(L *lua.LState) {
+	L.DoString(`if shell then shell:_release() end`)
+	Uninitialize(L)
+	L.Close()
+}
+
+// checkRefCount runs the script 100 times and fails when the reference
+// count of obj grows, that is, the script leaks the references.
+func checkRefCount(t *testing.T, L *lua.LState, obj *ole.IUnknown, name, script string) {
+	t.Helper()
+	refCount := func() int32 {
+		obj.AddRef()
+		return obj.Release()
+	}
+	before := refCount()
+	for i := 0; i < 100; i++ {
+		if err := L.DoString(script); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+	}
+	if after := refCount(); after > before {
+		t.Fatalf("%s: the reference count grew from %d to %d", name, before, after)
+	}
+}
+
+func TestChainRelease(t *testing.T) {
+	L, shell := newShell(t)
+	defer closeShell(L)
+
+	checkRefCount(t, L, &shell.IUnknown, "OBJ.member.member...", `
+		local w = shell.Application.Application.Application.Application.Application:Windows()
+		w:_release()`)
+}
+
+func TestFailedChainRelease(t *testing.T) {
+	L, shell := newShell(t)
+	defer closeShell(L)
+
+	checkRefCount(t, L, &shell.IUnknown, "OBJ.member.NoSuchMember", `
+		assert(not pcall(function()
+			return shell.Application.Application.NoSuchMember:Windows()
+		end))
+		local _, err = shell.Application:NoSuchMethod()
+		assert(err ~= nil)`)
+}
+
+func TestPathRelease(t *testing.T) {
+	L, shell := newShell(t)
+	defer closeShell(L)
+
+	checkRefCount(t, L, &shell.IUnknown, "OBJ:_path()", `
+		local app = assert(shell:_path("Application.Application.Application"))
+		app:_release()
+		local none, err = shell:_path("Application.Application.NoSuchMember")
+		assert(none == nil and err ~= nil)`)
+}
+
+func TestArrayRelease(t *testing.T) {
+	L, shell := newShell(t)
+	defer closeShell(L)
+
+	err := L.DoString(`
+		dic = create_object("Scripting.Dictionary")
+		dic:Add("shell", shell)`)
+	if err != nil {
+		t.Fatalf("Dictionary.Add: %s", err)
+	}
+	defer L.DoString(`dic:_release()`)
+
+	// the array holds its own references besides the elements.
+	checkRefCount(t, L, &shell.IUnknown, "Dictionary.Items", `
+		local items = dic:Items()
+		items[1]:_release()`)
+}
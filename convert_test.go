@@ -0,0 +1,637 @@
+package ole
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+func TestFormatScaled(t *testing.T) {
+	cases := []struct {
+		value  int64
+		scale  int
+		expect string
+	}{
+		{123456789, 4, "12345.6789"},
+		{123400, 4, "12.34"},
+		{-5000, 4, "-0.5"},
+		{1000000, 4, "100"},
+		{7, 4, "0.0007"},
+		{0, 4, "0"},
+	}
+	for _, c := range cases {
+		if result := formatScaled(big.NewInt(c.value), c.scale); result != c.expect {
+			t.Errorf("formatScaled(%d,%d)=%s (expected %s)", c.value, c.scale, result, c.expect)
+		}
+	}
+}
+
+func TestDecimalToBigInt(t *testing.T) {
+	var v ole.VARIANT
+	raw := (*[16]byte)(unsafe.Pointer(&v))
+	raw[2] = 3    // scale
+	raw[3] = 0x80 // sign
+	binary.LittleEndian.PutUint32(raw[4:8], 1)
+	binary.LittleEndian.PutUint64(raw[8:16], 5)
+
+	// -(2^64+5)/10^3
+	value, scale := decimalToBigInt(&v)
+	if result := formatScaled(value, scale); result != "-18446744073709551.621" {
+		t.Fatalf("decimalToBigInt: %s", result)
+	}
+}
+
+func TestTimeToOleDate(t *testing.T) {
+	cases := []struct {
+		t      time.Time
+		expect float64
+	}{
+		{time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC), 0},
+		{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), 36526},
+		{time.Date(2000, 1, 1, 18, 0, 0, 0, time.Local), 36526.75},
+		{time.Date(1899, 12, 29, 6, 0, 0, 0, time.UTC), -1.25},
+	}
+	for _, c := range cases {
+		if result := timeToOleDate(c.t); result != c.expect {
+			t.Errorf("timeToOleDate(%v)=%v (expected %v)", c.t, result, c.expect)
+		}
+	}
+}
+
+func TestOleDateToTime(t *testing.T) {
+	cases := []time.Time{
+		time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC),
+		time.Date(2000, 1, 1, 18, 0, 0, 0, time.UTC),
+		time.Date(2021, 3, 4, 5, 6, 7, 890*int(time.Millisecond), time.UTC),
+		time.Date(1899, 12, 29, 6, 0, 0, 0, time.UTC),
+		time.Date(1800, 7, 8, 23, 59, 59, 999*int(time.Millisecond), time.UTC),
+	}
+	for _, c := range cases {
+		if result := oleDateToTime(timeToOleDate(c), time.UTC); !result.Equal(c) {
+			t.Errorf("oleDateToTime(timeToOleDate(%v))=%v", c, result)
+		}
+	}
+}
+
+func TestIntegerToLValue(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	cases := []struct {
+		v      ole.VARIANT
+		expect string
+	}{
+		{ole.NewVariant(ole.VT_I1, 0xFF), "-1"},
+		{ole.NewVariant(ole.VT_I2, 0x7FFF), "32767"},
+		{ole.NewVariant(ole.VT_I4, 5), "5"},
+		{ole.NewVariant(ole.VT_INT, 0x7FFFFFFF00000003), "3"},
+		{ole.NewVariant(ole.VT_UI1, 0x1FF), "255"},
+		{ole.NewVariant(ole.VT_UI4, -1), "4294967295"},
+	}
+	for _, c := range cases {
+		val, err := variantToLValue(L, &c.v)
+		if err != nil {
+			t.Fatalf("variantToLValue(%v): %s", c.v.VT, err)
+		}
+		if result := L.ToStringMeta(val).String(); result != c.expect {
+			t.Errorf("variantToLValue(%v)=%s (expected %s)", c.v.VT, result, c.expect)
+		}
+	}
+}
+
+func TestByRefVariantToLValue(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	inner := ole.NewVariant(ole.VT_I4, 42)
+	v := ole.NewVariant(ole.VT_VARIANT|ole.VT_BYREF, int64(uintptr(unsafe.Pointer(&inner))))
+	val, err := variantToLValue(L, &v)
+	if err != nil {
+		t.Fatalf("variantToLValue(VT_VARIANT|VT_BYREF): %s", err)
+	}
+	if val != lua.LNumber(42) {
+		t.Fatalf("variantToLValue(VT_VARIANT|VT_BYREF)=%v (expected 42)", val)
+	}
+
+	null := ole.NewVariant(ole.VT_VARIANT|ole.VT_BYREF, 0)
+	if val, err := variantToLValue(L, &null); err != nil || val != lua.LNil {
+		t.Fatalf("variantToLValue(VT_VARIANT|VT_BYREF to NULL)=%v,%v", val, err)
+	}
+}
+
+func TestDateAsUserData(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	DateAsUserData, DateLocation = true, time.UTC
+	defer func() { DateAsUserData, DateLocation = false, time.Local }()
+
+	v := ole.NewVariant(ole.VT_DATE, int64(math.Float64bits(36526.75)))
+	val, err := variantToLValue(L, &v)
+	if err != nil {
+		t.Fatalf("variantToLValue(VT_DATE): %s", err)
+	}
+	L.SetGlobal("d", val)
+	err = L.DoString(`
+		assert(d:format("2006-01-02 15:04") == "2000-01-01 18:00")
+		assert(d:unix() == 946749600)`)
+	if err != nil {
+		t.Fatalf("VT_DATE as userdata: %s", err)
+	}
+	param, err := lvalue2interface(L, val)
+	if err != nil {
+		t.Fatalf("lvalue2interface(date): %s", err)
+	}
+	if p, ok := param.(*ole.VARIANT); !ok || p.VT != ole.VT_DATE || math.Float64frombits(uint64(p.Val)) != 36526.75 {
+		t.Fatalf("lvalue2interface(date)=%v", param)
+	}
+}
+
+func TestNullDispatchToLValue(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	for _, vt := range []ole.VT{ole.VT_DISPATCH, ole.VT_UNKNOWN} {
+		v := ole.NewVariant(vt, 0)
+		val, err := variantToLValue(L, &v)
+		if err != nil || val != lua.LNil {
+			t.Errorf("variantToLValue(null %v)=%v,%v (expected nil)", vt, val, err)
+		}
+	}
+}
+
+func TestBoolToLValue(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	cases := []struct {
+		v      ole.VARIANT
+		expect lua.LValue
+	}{
+		{ole.NewVariant(ole.VT_BOOL, 0xFFFF), lua.LTrue},
+		{ole.NewVariant(ole.VT_BOOL, 1), lua.LTrue},
+		{ole.NewVariant(ole.VT_BOOL, 0), lua.LFalse},
+		{ole.NewVariant(ole.VT_BOOL, 0x7FFF0000), lua.LFalse},
+	}
+	for _, c := range cases {
+		val, err := variantToLValue(L, &c.v)
+		if err != nil {
+			t.Fatalf("variantToLValue(VT_BOOL %X): %s", c.v.Val, err)
+		}
+		if val != c.expect {
+			t.Errorf("variantToLValue(VT_BOOL %X)=%v (expected %v)", c.v.Val, val, c.expect)
+		}
+	}
+}
+
+func TestLargeIntegerAsString(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	LargeIntegerAsString = true
+	defer func() { LargeIntegerAsString = false }()
+
+	cases := []struct {
+		v      ole.VARIANT
+		expect lua.LValue
+	}{
+		{ole.NewVariant(ole.VT_I8, 9007199254740993), lua.LString("9007199254740993")},
+		{ole.NewVariant(ole.VT_I8, -9007199254740993), lua.LString("-9007199254740993")},
+		{ole.NewVariant(ole.VT_UI8, -1), lua.LString("18446744073709551615")},
+		{ole.NewVariant(ole.VT_I8, 9007199254740992), lua.LNumber(9007199254740992)},
+		{ole.NewVariant(ole.VT_I4, 5), lua.LNumber(5)},
+	}
+	for _, c := range cases {
+		val, err := variantToLValue(L, &c.v)
+		if err != nil {
+			t.Fatalf("variantToLValue(%v %d): %s", c.v.VT, c.v.Val, err)
+		}
+		if val != c.expect {
+			t.Errorf("variantToLValue(%v %d)=%#v (expected %#v)", c.v.VT, c.v.Val, val, c.expect)
+		}
+	}
+}
+
+// newBstrArray makes VT_ARRAY|VT_BSTR of n strings, or skips without SAFEARRAY.
+func newBstrArray(tb testing.TB, n int) *ole.VARIANT {
+	sa, err := safeArrayCreate(ole.VT_BSTR, []ole.SafeArrayBound{{Elements: uint32(n)}})
+	if err != nil {
+		tb.Skipf("SafeArrayCreate: %s", err)
+	}
+	for i := 0; i < n; i++ {
+		bstr := ole.SysAllocString(fmt.Sprintf("file%05d.txt", i))
+		err := safeArrayPutElement(sa, []int32{int32(i)}, unsafe.Pointer(bstr))
+		ole.SysFreeString(bstr)
+		if err != nil {
+			tb.Fatalf("SafeArrayPutElement: %s", err)
+		}
+	}
+	v := ole.NewVariant(ole.VT_ARRAY|ole.VT_BSTR, int64(uintptr(unsafe.Pointer(sa))))
+	return &v
+}
+
+func TestBstrArrayToLValue(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	v := newBstrArray(t, 3)
+	defer v.Clear()
+	val, err := variantToLValue(L, v)
+	if err != nil {
+		t.Fatalf("variantToLValue(VT_ARRAY|VT_BSTR): %s", err)
+	}
+	list, ok := val.(*lua.LTable)
+	if !ok || list.Len() != 3 {
+		t.Fatalf("variantToLValue(VT_ARRAY|VT_BSTR)=%v (expected 3 strings)", val)
+	}
+	if s := list.RawGetInt(3); s != lua.LString("file00002.txt") {
+		t.Fatalf("variantToLValue(VT_ARRAY|VT_BSTR)[3]=%v (expected file00002.txt)", s)
+	}
+}
+
+func TestByRefArrayToLValue(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	v := newBstrArray(t, 3)
+	defer v.Clear()
+	// the event arguments and the ref parameters refer to SAFEARRAY*.
+	sa := *(**ole.SafeArray)(unsafe.Pointer(&v.Val))
+	ref := ole.NewVariant(ole.VT_ARRAY|ole.VT_BSTR|ole.VT_BYREF, int64(uintptr(unsafe.Pointer(&sa))))
+	val, err := variantToLValue(L, &ref)
+	if err != nil {
+		t.Fatalf("variantToLValue(VT_ARRAY|VT_BSTR|VT_BYREF): %s", err)
+	}
+	list, ok := val.(*lua.LTable)
+	if !ok || list.Len() != 3 {
+		t.Fatalf("variantToLValue(VT_ARRAY|VT_BSTR|VT_BYREF)=%v (expected 3 strings)", val)
+	}
+	if s := list.RawGetInt(1); s != lua.LString("file00000.txt") {
+		t.Fatalf("variantToLValue(VT_ARRAY|VT_BSTR|VT_BYREF)[1]=%v (expected file00000.txt)", s)
+	}
+
+	var none *ole.SafeArray
+	ref = ole.NewVariant(ole.VT_ARRAY|ole.VT_BSTR|ole.VT_BYREF, int64(uintptr(unsafe.Pointer(&none))))
+	if val, err := variantToLValue(L, &ref); err != nil || val.(*lua.LTable).Len() != 0 {
+		t.Fatalf("variantToLValue(NULL VT_ARRAY|VT_BYREF)=%v,%v (expected an empty table)", val, err)
+	}
+}
+
+func TestNumberArrayRoundTrip(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	for _, vt := range []ole.VT{ole.VT_R8, ole.VT_R4} {
+		a := &numberArrayT{vt: vt, values: []float64{1.5, -2.25, 4}}
+		v, err := a.toVariant()
+		if err != nil {
+			t.Skipf("numberArrayT.toVariant: %s", err)
+		}
+		val, err := variantToLValue(L, v)
+		v.Clear()
+		if err != nil {
+			t.Fatalf("variantToLValue(VT_ARRAY|%v): %s", vt, err)
+		}
+		list, ok := val.(*lua.LTable)
+		if !ok || list.Len() != 3 || list.RawGetInt(2) != lua.LNumber(-2.25) {
+			t.Fatalf("variantToLValue(VT_ARRAY|%v)=%v (expected {1.5,-2.25,4})", vt, val)
+		}
+	}
+}
+
+func BenchmarkBstrArray(b *testing.B) {
+	const n = 10000
+	L := lua.NewState()
+	defer L.Close()
+
+	v := newBstrArray(b, n)
+	defer v.Clear()
+	sa := v.ToArray().Array
+
+	b.Run("fast", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := variantToLValue(L, v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := safeArrayDimToLValue(L, sa, ole.VT_BSTR, 0,
+				[]int32{0}, []int32{n - 1}, []int32{0})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestUnknownArrayToLValue(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	sa, err := safeArrayCreate(ole.VT_UNKNOWN, []ole.SafeArrayBound{{Elements: 3}})
+	if err != nil {
+		t.Skipf("SafeArrayCreate: %s", err)
+	}
+	ole.CoInitialize(0)
+	defer ole.CoUninitialize()
+
+	// the global interface table supports IUnknown but not IDispatch.
+	git, err := ole.CreateInstance(ole.NewGUID("{00000323-0000-0000-C000-000000000046}"), ole.IID_IUnknown)
+	if err != nil {
+		t.Fatalf("CreateInstance(StdGlobalInterfaceTable): %s", err)
+	}
+	callback := newCallback(func([]ole.VARIANT, *ole.VARIANT, *excepInfoT) uintptr { return ole.S_OK })
+	for i, obj := range []unsafe.Pointer{unsafe.Pointer(callback), unsafe.Pointer(git), unsafe.Pointer(callback)} {
+		if err := safeArrayPutElement(sa, []int32{int32(i)}, obj); err != nil {
+			t.Fatalf("SafeArrayPutElement: %s", err)
+		}
+	}
+	git.Release()
+	callback.Release()
+
+	v := ole.NewVariant(ole.VT_ARRAY|ole.VT_UNKNOWN, int64(uintptr(unsafe.Pointer(sa))))
+	val, err := variantToLValue(L, &v)
+	v.Clear()
+	if err != nil {
+		t.Fatalf("variantToLValue(VT_ARRAY|VT_UNKNOWN): %s", err)
+	}
+	list := val.(*lua.LTable)
+	for i, isObject := range []bool{true, false, true} {
+		elem := list.RawGetInt(i + 1)
+		if _, ok := elem.(*lua.LUserData); ok != isObject {
+			t.Errorf("variantToLValue(VT_ARRAY|VT_UNKNOWN)[%d]=%v", i+1, elem)
+		}
+		releaseLValue(elem)
+	}
+}
+
+func TestParseCoinit(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flags := L.NewTable()
+	flags.Append(lua.LString("MTA"))
+	flags.Append(lua.LString("speed_over_memory"))
+	cases := []struct {
+		value  lua.LValue
+		expect uint32
+	}{
+		{lua.LNil, ole.COINIT_APARTMENTTHREADED},
+		{lua.LString("mta"), ole.COINIT_MULTITHREADED},
+		{lua.LString("sta|disable_ole1dde"), ole.COINIT_APARTMENTTHREADED | ole.COINIT_DISABLE_OLE1DDE},
+		{lua.LString("disable_ole1dde"), ole.COINIT_APARTMENTTHREADED | ole.COINIT_DISABLE_OLE1DDE},
+		{flags, ole.COINIT_MULTITHREADED | ole.COINIT_SPEED_OVER_MEMORY},
+		{lua.LNumber(6), ole.COINIT_APARTMENTTHREADED | ole.COINIT_DISABLE_OLE1DDE},
+	}
+	for _, c := range cases {
+		coinit, err := parseCoinit(c.value)
+		if err != nil {
+			t.Fatalf("parseCoinit(%v): %s", c.value, err)
+		}
+		if coinit != c.expect {
+			t.Errorf("parseCoinit(%v)=0x%X (expected 0x%X)", c.value, coinit, c.expect)
+		}
+	}
+	for _, value := range []lua.LValue{lua.LString("sta,bogus"), lua.LString("sta mta"), lua.LNumber(0x10), lua.LTrue} {
+		if _, err := parseCoinit(value); err == nil {
+			t.Errorf("parseCoinit(%v) accepted the invalid flags", value)
+		}
+	}
+}
+
+func TestSequenceLen(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	if err := L.DoString(`
+		list = { "a","b","c" }
+		gap = { 1, nil, 3 }
+		map = { 1, 2, x=3 }`); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sequenceLen(L.GetGlobal("list").(*lua.LTable)); err != nil || n != 3 {
+		t.Errorf("sequenceLen({a,b,c})=%d,%v (expected 3)", n, err)
+	}
+	if _, err := sequenceLen(L.GetGlobal("gap").(*lua.LTable)); err == nil || !strings.Contains(err.Error(), "gap at [2]") {
+		t.Errorf("sequenceLen({1,nil,3}) gave %v (expected the gap at [2])", err)
+	}
+	if _, err := sequenceLen(L.GetGlobal("map").(*lua.LTable)); err == nil {
+		t.Error("sequenceLen({1,2,x=3}) accepted the map key")
+	}
+}
+
+func TestTableLowerBound(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	if err := L.DoString(`list = { "a","b","c" }`); err != nil {
+		t.Fatal(err)
+	}
+	for _, lower := range []int32{0, 1} {
+		v, err := tableToVariantLower(L, L.GetGlobal("list").(*lua.LTable), lower)
+		if err != nil {
+			t.Skipf("tableToVariantLower: %s", err)
+		}
+		lbound, ubound, err := safeArrayGetBounds(v.ToArray().Array, 1)
+		if err != nil || lbound != lower || ubound != lower+2 {
+			t.Errorf("bounds of lower %d = %d..%d, %v", lower, lbound, ubound, err)
+		}
+		val, err := variantToLValue(L, v)
+		v.Clear()
+		if err != nil {
+			t.Fatalf("variantToLValue: %s", err)
+		}
+		if list := val.(*lua.LTable); list.Len() != 3 || list.RawGetInt(1) != lua.LString("a") {
+			t.Errorf("variantToLValue(lower %d)=%v (expected {a,b,c})", lower, val)
+		}
+	}
+}
+
+func TestOleParam(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	cases := []struct {
+		fn   lua.LGFunction
+		arg  lua.LValue
+		vt   ole.VT
+		want interface{}
+	}{
+		{ToOleInteger, lua.LNumber(7), ole.VT_I4, int(7)},
+		{ToOleInt64, lua.LString("9007199254740993"), ole.VT_I8, int64(9007199254740993)},
+		{ToOleByte, lua.LNumber(255), ole.VT_UI1, uint8(255)},
+		{ToOleUInt, lua.LNumber(4294967295), ole.VT_UI4, uint32(4294967295)},
+		{ToOleString, lua.LNumber(1234), ole.VT_BSTR, "1234"},
+		{ToOleBool, lua.LString(""), ole.VT_BOOL, false},
+		{ToOleNull, lua.LNil, ole.VT_NULL, nil},
+		{ToOleEmpty, lua.LNil, ole.VT_EMPTY, nil},
+		{ToOleMissing, lua.LNil, ole.VT_ERROR, missingT{}},
+	}
+	for _, c := range cases {
+		L.Push(L.NewFunction(c.fn))
+		L.Push(c.arg)
+		L.Call(1, 1)
+		ud, ok := L.Get(-1).(*lua.LUserData)
+		L.Pop(1)
+		if !ok {
+			t.Fatalf("%v: not a userdata", c.vt)
+		}
+		p, ok := ud.Value.(*oleParam)
+		if !ok {
+			t.Fatalf("%v: %T: not an oleParam", c.vt, ud.Value)
+		}
+		if p.VT != c.vt || p.Value != c.want {
+			t.Errorf("%v: got {%v %#v}, want %#v", c.vt, p.VT, p.Value, c.want)
+		}
+		value, err := lvalue2interface(L, ud)
+		if err != nil {
+			t.Fatalf("%v: lvalue2interface: %s", c.vt, err)
+		}
+		v, err := toVariant(value)
+		if err != nil {
+			t.Fatalf("%v: toVariant: %s", c.vt, err)
+		}
+		if v.VT != c.vt {
+			t.Errorf("%v: toVariant made %v", c.vt, v.VT)
+		}
+		if v.VT == ole.VT_BSTR {
+			v.Clear()
+		}
+	}
+}
+
+func TestOleDateFloat(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	for _, f := range []float64{45000.5, 0, -1.25, 2958465.999988426} {
+		L.Push(L.NewFunction(ToOleDate))
+		L.Push(lua.LNumber(f))
+		L.Call(1, 1)
+		value, err := lvalue2interface(L, L.Get(-1))
+		L.Pop(1)
+		if err != nil {
+			t.Fatalf("to_ole_date(%v): %s", f, err)
+		}
+		v, ok := value.(*ole.VARIANT)
+		if !ok || v.VT != ole.VT_DATE {
+			t.Fatalf("to_ole_date(%v) made %#v", f, value)
+		}
+		if got := math.Float64frombits(uint64(v.Val)); got != f {
+			t.Errorf("to_ole_date(%v) made %v", f, got)
+		}
+		// the result read back becomes the same date again.
+		back := timeToOleDate(oleDateToTime(f, time.UTC))
+		if math.Abs(back-f) > 1.0/86400/1000 {
+			t.Errorf("to_ole_date(%v) read back as %v", f, back)
+		}
+	}
+
+	L.Push(L.NewFunction(ToOleDate))
+	L.Push(lua.LNumber(math.NaN()))
+	L.Call(1, 2)
+	if L.Get(-2) != lua.LNil {
+		t.Errorf("to_ole_date(NaN) has to fail")
+	}
+}
+
+func TestBstrToString(t *testing.T) {
+	// BSTR made in Go: the byte length, the UTF-16 characters and NUL
+	newBstr := func(units []uint16) *uint16 {
+		buf := make([]uint16, len(units)+3)
+		binary.LittleEndian.PutUint32((*[4]byte)(unsafe.Pointer(&buf[0]))[:], uint32(len(units)*2))
+		copy(buf[2:], units)
+		return &buf[2]
+	}
+	cases := []struct {
+		units []uint16
+		want  string
+	}{
+		{nil, ""},
+		{[]uint16{'a', 'b', 'c'}, "abc"},
+		// 日本語.txt
+		{[]uint16{0x65E5, 0x672C, 0x8A9E, '.', 't', 'x', 't'}, "日本語.txt"},
+		// U+1F600 as the surrogate pair
+		{[]uint16{0xD83D, 0xDE00}, "\U0001F600"},
+		{[]uint16{'a', 0, 'b'}, "a\x00b"},
+		{[]uint16{0xD83D, 'a'}, "�a"},
+	}
+	for _, c := range cases {
+		if got := bstrToString(newBstr(c.units)); got != c.want {
+			t.Errorf("bstrToString(%04X)=%q (expected %q)", c.units, got, c.want)
+		}
+	}
+	if got := bstrToString(nil); got != "" {
+		t.Errorf("bstrToString(nil)=%q", got)
+	}
+}
+
+func TestVariantHook(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	defer func() { VariantHook = nil }()
+	VariantHook = func(L *lua.LState, v *ole.VARIANT) lua.LValue {
+		if v.VT != ole.VT_DATE {
+			return nil
+		}
+		date := oleDateToTime(math.Float64frombits(uint64(v.Val)), time.UTC)
+		return lua.LString(date.Format(time.RFC3339))
+	}
+	date := ole.NewVariant(ole.VT_DATE, int64(math.Float64bits(45000.5)))
+	val, err := variantToLValue(L, &date)
+	if err != nil {
+		t.Fatalf("variantToLValue(VT_DATE): %s", err)
+	}
+	if val != lua.LString("2023-03-15T12:00:00Z") {
+		t.Errorf("variantToLValue(VT_DATE) with the hook=%v", val)
+	}
+	// nil from the hook falls back to the default conversion.
+	r8 := ole.NewVariant(ole.VT_R8, int64(math.Float64bits(1.5)))
+	val, err = variantToLValue(L, &r8)
+	if err != nil || val != lua.LNumber(1.5) {
+		t.Errorf("variantToLValue(VT_R8) with the hook=%v,%v", val, err)
+	}
+}
+
+func TestParamHook(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	defer func() { ParamHook = nil }()
+	ParamHook = func(L *lua.LState, value lua.LValue) (interface{}, bool) {
+		t, ok := value.(*lua.LTable)
+		if !ok {
+			return nil, false
+		}
+		amount, ok := L.GetField(t, "currency").(lua.LNumber)
+		if !ok {
+			return nil, false
+		}
+		v := ole.NewVariant(ole.VT_CY, int64(math.Round(float64(amount)*10000)))
+		return &v, true
+	}
+	money := L.NewTable()
+	L.SetField(money, "currency", lua.LNumber(12.34))
+	value, err := lvalue2interface(L, money)
+	if err != nil {
+		t.Fatalf("lvalue2interface({currency=12.34}): %s", err)
+	}
+	if v, ok := value.(*ole.VARIANT); !ok || v.VT != ole.VT_CY || v.Val != 123400 {
+		t.Errorf("lvalue2interface({currency=12.34})=%#v", value)
+	}
+	// false from the hook falls back to the default conversion.
+	value, err = lvalue2interface(L, lua.LNumber(1.5))
+	if err != nil || value != 1.5 {
+		t.Errorf("lvalue2interface(1.5) with the hook=%v,%v", value, err)
+	}
+}
@@ -0,0 +1,39 @@
+package ole
+
+import (
+	"testing"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+func TestCreateObjectWithoutDispatch(t *testing.T) {
+	L := lua.NewState()
+	defer func() {
+		Uninitialize(L)
+		L.Close()
+	}()
+	L.SetGlobal("create_object", L.NewFunction(CreateObject))
+	initialize(ole.COINIT_APARTMENTTHREADED)
+
+	// the global interface table is a singleton without IDispatch,
+	// so a leak of create_object remains in its reference count.
+	const clsid = "{00000323-0000-0000-C000-000000000046}"
+	git, err := ole.CreateInstance(ole.NewGUID(clsid), ole.IID_IUnknown)
+	if err != nil {
+		t.Fatalf("CreateInstance(StdGlobalInterfaceTable): %s", err)
+	}
+	defer git.Release()
+	checkRefCount(t, L, git, "create_object(no IDispatch)", `
+		local obj, err = create_object("`+clsid+`")
+		assert(obj == nil)
+		assert(string.find(err,"does not support IDispatch",1,true))
+		assert(not string.find(err,"oleutil.CreateObject",1,true))`)
+	err = L.DoString(`
+		local obj, err = create_object("No.Such.ProgID")
+		assert(obj == nil)
+		assert(string.find(err,"oleutil.CreateObject",1,true))`)
+	if err != nil {
+		t.Fatalf("create_object(no such ProgID): %s", err)
+	}
+}
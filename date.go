@@ -0,0 +1,97 @@
+package ole
+
+import (
+	"math"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// DateAsUserData makes VT_DATE values converted into the userdata of
+// time.Time with the methods :unix() and :format(layout) instead of
+// the tables. The userdata can be given back to the parameters.
+var DateAsUserData = false
+
+const dateTypeName = "ole.date"
+
+// DateLocation is the location of the wall clock of VT_DATE, which has no
+// timezone. VT_DATE results are converted by it and to_ole_date makes
+// the time in it unless utc=true is given.
+var DateLocation = time.Local
+
+// oleEpoch is the day zero of OLE Automation dates (VT_DATE).
+var oleEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// timeToOleDate converts time.Time into OLE Automation date, which is the
+// days since 1899-12-30 with the time of the day as the fraction.
+// VT_DATE has no timezone, so the wall clock of t in its own location
+// is used as it is. (A time of the local location keeps its local time)
+func timeToOleDate(t time.Time) float64 {
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	days := float64((day.Unix() - oleEpoch.Unix()) / 86400)
+	frac := float64(t.Hour()*3600+t.Minute()*60+t.Second())/86400 +
+		float64(t.Nanosecond())/(86400*1e9)
+	if days < 0 {
+		// the fraction of the negative dates still means the time after midnight.
+		return days - frac
+	}
+	return days + frac
+}
+
+// oleDateToTime converts OLE Automation date into the wall clock in loc
+// rounded to milliseconds, which is the precision of VT_DATE in practice.
+func oleDateToTime(d float64, loc *time.Location) time.Time {
+	days := math.Trunc(d)
+	msec := int64(math.Round(math.Abs(d-days) * 86400 * 1000))
+	// time.Date normalizes the nanoseconds on the wall clock,
+	// so it is not shifted on the days of DST.
+	return time.Date(1899, 12, 30+int(days), 0, 0, 0, int(msec*int64(time.Millisecond)), loc)
+}
+
+// dateMeta returns the metatable of the date userdata like capsuleMeta.
+func dateMeta(L *lua.LState) *lua.LTable {
+	if meta, ok := L.GetTypeMetatable(dateTypeName).(*lua.LTable); ok {
+		return meta
+	}
+	meta := L.NewTypeMetatable(dateTypeName)
+	L.SetField(meta, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"unix":   dateUnix,
+		"format": dateFormat,
+	}))
+	L.SetField(meta, "__tostring", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(checkDate(L).Format("2006-01-02 15:04:05.000")))
+		return 1
+	}))
+	return meta
+}
+
+func newDate(L *lua.LState, t time.Time) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = t
+	L.SetMetatable(ud, dateMeta(L))
+	return ud
+}
+
+func checkDate(L *lua.LState) time.Time {
+	t, ok := L.CheckUserData(1).Value.(time.Time)
+	if !ok {
+		L.ArgError(1, "not a date")
+	}
+	return t
+}
+
+// D:unix() returns the seconds since 1970-01-01 UTC with milliseconds.
+func dateUnix(L *lua.LState) int {
+	t := checkDate(L)
+	L.Push(lua.LNumber(float64(t.UnixNano()/int64(time.Millisecond)) / 1000))
+	return 1
+}
+
+// D:format(layout) formats by the layout of Go's time package
+// (default "2006-01-02 15:04:05").
+func dateFormat(L *lua.LState) int {
+	t := checkDate(L)
+	L.Push(lua.LString(t.Format(L.OptString(2, "2006-01-02 15:04:05"))))
+	return 1
+}
@@ -0,0 +1,200 @@
+package ole
+
+import (
+	"fmt"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+// EnumBatchSize is the count of the items which the enumerators of _iter,
+// _enum and pairs fetch by one IEnumVARIANT.Next call. Larger values reduce
+// the round-trips for large (or out-of-process) collections.
+var EnumBatchSize = 1
+
+// next returns the next item from the buffer, or fetches the items
+// when the buffer is drained. It returns false at the end.
+func (e *enumeratorT) next() (ole.VARIANT, bool, error) {
+	if e.pos < e.count {
+		v := e.buffer[e.pos]
+		e.buffer[e.pos] = ole.VARIANT{}
+		e.pos++
+		return v, true, nil
+	}
+	if EnumBatchSize <= 1 {
+		v, length, err := e.enum.Next(1)
+		if err != nil {
+			if code, ok := oleErrorCode(err); ok && code == 1 { // S_FALSE
+				return v, false, nil
+			}
+			return v, false, err
+		}
+		return v, length > 0, nil
+	}
+	if len(e.buffer) != EnumBatchSize {
+		e.buffer = make([]ole.VARIANT, EnumBatchSize)
+	}
+	count, err := enumNext(e.enum, e.buffer)
+	if err != nil {
+		return ole.VARIANT{}, false, err
+	}
+	e.pos, e.count = 0, count
+	if count <= 0 {
+		return ole.VARIANT{}, false, nil
+	}
+	return e.next()
+}
+
+// discard clears the items in the buffer which are not returned yet.
+func (e *enumeratorT) discard() {
+	for ; e.pos < e.count; e.pos++ {
+		e.buffer[e.pos].Clear()
+	}
+}
+
+// this:_enum() returns the enumerator of _NewEnum which is not closed
+// at the end unlike _iter, so that E:_reset() can restart it.
+// E:_next() (or E()) returns the next item, and E:_close() releases it.
+func openEnum(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_enum: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_enum: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_enum: "+p.nullError())
+	}
+	e, err := newEnumerator(p.Data)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_enum: %s", err.Error()))
+	}
+	e.keep = true
+	L.Push(e.ToLValue(L))
+	return 1
+}
+
+func checkEnumerator(L *lua.LState, name string) (*enumeratorT, bool) {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		lerror(L, name+": no receiver")
+		return nil, false
+	}
+	e, ok := ud.Value.(*enumeratorT)
+	if !ok || e.enum == nil {
+		lerror(L, name+": the enumerator is closed")
+		return nil, false
+	}
+	return e, true
+}
+
+// E:_reset() restarts the enumeration by IEnumVARIANT.Reset
+func enumReset(L *lua.LState) int {
+	e, ok := checkEnumerator(L, "_reset")
+	if !ok {
+		return 2
+	}
+	e.discard()
+	if err := e.enum.Reset(); err != nil {
+		return lerror(L, fmt.Sprintf("_reset: %s", err.Error()))
+	}
+	e.index = 0
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// E:_skip(N) skips N items by IEnumVARIANT.Skip.
+// It returns false when the enumeration reaches the end.
+func enumSkip(L *lua.LState) int {
+	e, ok := checkEnumerator(L, "_skip")
+	if !ok {
+		return 2
+	}
+	n := L.OptInt(2, 1)
+	if n < 0 {
+		return lerror(L, "_skip: the count is negative")
+	}
+	e.index += n
+	n -= e.skipBuffer(n)
+	if n == 0 {
+		L.Push(lua.LTrue)
+		return 1
+	}
+	err := e.enum.Skip(uint(n))
+	if err != nil {
+		if code, ok := oleErrorCode(err); ok && code == 1 { // S_FALSE
+			L.Push(lua.LFalse)
+			return 1
+		}
+		return lerror(L, fmt.Sprintf("_skip: %s", err.Error()))
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// E:_close() releases the enumerator.
+func enumClose(L *lua.LState) int {
+	if ud, ok := L.Get(1).(*lua.LUserData); ok {
+		if e, ok := ud.Value.(*enumeratorT); ok {
+			e.Close()
+			ud.Value = nil
+		}
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// skipBuffer skips n items in the buffer at most and returns the count.
+func (e *enumeratorT) skipBuffer(n int) int {
+	skipped := 0
+	for ; skipped < n && e.pos < e.count; skipped++ {
+		e.buffer[e.pos].Clear()
+		e.pos++
+	}
+	return skipped
+}
+
+// this:_toarray() returns the items of _NewEnum as a Lua array.
+func toArray(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_toarray: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_toarray: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_toarray: "+p.nullError())
+	}
+	e, err := newEnumerator(p.Data)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_toarray: %s", err.Error()))
+	}
+	array := L.NewTable()
+	// fail releases the items converted before the failure.
+	fail := func(err error) int {
+		releaseLValue(array)
+		e.Close()
+		return lerror(L, fmt.Sprintf("_toarray: %s", err.Error()))
+	}
+	for i := 1; ; i++ {
+		item, ok, err := e.next()
+		if err != nil {
+			return fail(err)
+		}
+		if !ok {
+			break
+		}
+		val, err := resultToLValue(L, &item)
+		if err != nil {
+			return fail(err)
+		}
+		array.RawSetInt(i, val)
+	}
+	e.Close()
+	L.Push(array)
+	return 1
+}
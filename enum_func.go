@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+import (
+	"github.com/go-ole/go-ole"
+)
+
+func enumNext(enum *ole.IEnumVARIANT, buffer []ole.VARIANT) (int, error) {
+	return 0, ole.NewError(ole.E_NOTIMPL)
+}
@@ -0,0 +1,28 @@
+package ole
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+// enumNext is IEnumVARIANT.Next which fills the buffer,
+// because ole.IEnumVARIANT.Next can receive only one item.
+// It returns the count of the fetched items, less than the buffer at the end.
+func enumNext(enum *ole.IEnumVARIANT, buffer []ole.VARIANT) (int, error) {
+	var fetched uint32
+	hr, _, _ := syscall.Syscall6(
+		enum.VTable().Next,
+		4,
+		uintptr(unsafe.Pointer(enum)),
+		uintptr(len(buffer)),
+		uintptr(unsafe.Pointer(&buffer[0])),
+		uintptr(unsafe.Pointer(&fetched)),
+		0,
+		0)
+	if hr != 0 && hr != 1 { // S_FALSE means the end
+		return 0, ole.NewError(hr)
+	}
+	return int(fetched), nil
+}
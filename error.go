@@ -0,0 +1,132 @@
+package ole
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+// excepInfoT has the same layout as ole.EXCEPINFO whose fields are not exported.
+type excepInfoT struct {
+	wCode             uint16
+	wReserved         uint16
+	bstrSource        *uint16
+	bstrDescription   *uint16
+	bstrHelpFile      *uint16
+	dwHelpContext     uint32
+	pvReserved        uintptr
+	pfnDeferredFillIn uintptr
+	scode             uint32
+}
+
+// excepInfoOf returns EXCEPINFO which the COM server filled on the failure.
+func excepInfoOf(err error) (*excepInfoT, bool) {
+	oleErr, ok := err.(*ole.OleError)
+	if !ok {
+		return nil, false
+	}
+	e, ok := oleErr.SubError().(ole.EXCEPINFO)
+	if !ok {
+		return nil, false
+	}
+	return (*excepInfoT)(unsafe.Pointer(&e)), true
+}
+
+// freeExcepInfo frees the BSTRs of EXCEPINFO in the error of
+// IDispatch.Invoke, which belong to the caller. Do not use the error
+// after it.
+func freeExcepInfo(err error) {
+	e, ok := excepInfoOf(err)
+	if !ok {
+		return
+	}
+	for _, bstr := range []*uint16{e.bstrSource, e.bstrDescription, e.bstrHelpFile} {
+		if bstr != nil {
+			ole.SysFreeString((*int16)(unsafe.Pointer(bstr)))
+		}
+	}
+}
+
+func (e *excepInfoT) code() uint32 {
+	if e.wCode != 0 {
+		return uint32(e.wCode)
+	}
+	return e.scode
+}
+
+const errorTypeName = "ole.error"
+
+// errorMeta returns the metatable of the error tables
+// whose tostring() is the message.
+func errorMeta(L *lua.LState) *lua.LTable {
+	if meta, ok := L.GetTypeMetatable(errorTypeName).(*lua.LTable); ok {
+		return meta
+	}
+	meta := L.NewTypeMetatable(errorTypeName)
+	L.SetField(meta, "__tostring", L.NewFunction(func(L *lua.LState) int {
+		L.Push(L.GetField(L.CheckTable(1), "message"))
+		return 1
+	}))
+	return meta
+}
+
+// comError pushes nil and the error of the COM call.
+// The error is a table {message,code,hex} for the HRESULT (or SCODE of
+// EXCEPINFO) with {source,description,helpfile,helpcontext} when the server filled
+// EXCEPINFO. For errors not of COM, it is the string s.
+// It frees the BSTRs of EXCEPINFO, so the error must not be used after it.
+func comError(L *lua.LState, err error, s string) int {
+	oleErr, ok := err.(*ole.OleError)
+	if !ok {
+		return lerror(L, s)
+	}
+	code := uint32(oleErr.Code())
+	t := L.NewTable()
+	L.SetField(t, "message", lua.LString(s))
+	if e, ok := excepInfoOf(err); ok {
+		// the fields are set only when the server filled them.
+		for _, f := range []struct {
+			name string
+			bstr *uint16
+		}{
+			{"source", e.bstrSource},
+			{"description", e.bstrDescription},
+			{"helpfile", e.bstrHelpFile},
+		} {
+			if f.bstr != nil {
+				L.SetField(t, f.name, lua.LString(bstrToString(f.bstr)))
+			}
+		}
+		if e.dwHelpContext != 0 {
+			L.SetField(t, "helpcontext", lua.LNumber(e.dwHelpContext))
+		}
+		// go-ole attaches EXCEPINFO to every failure, but it is filled
+		// only for DISP_E_EXCEPTION. The others keep their HRESULTs.
+		if code == dispEException && e.code() != 0 {
+			code = e.code()
+		}
+		freeExcepInfo(err)
+	}
+	L.SetField(t, "code", lua.LNumber(code))
+	L.SetField(t, "hex", lua.LString(fmt.Sprintf("0x%08X", code)))
+	L.SetMetatable(t, errorMeta(L))
+	if RaiseErrors {
+		logln(s)
+		L.Error(t, 1)
+	}
+	L.Push(lua.LNil)
+	L.Push(t)
+	logln(s)
+	return 2
+}
+
+// oleErrorCode returns the HRESULT of the error of go-ole.
+func oleErrorCode(err error) (uint32, bool) {
+	oleErr, ok := err.(*ole.OleError)
+	if !ok {
+		return 0, false
+	}
+	return uint32(oleErr.Code()), true
+}
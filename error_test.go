@@ -0,0 +1,74 @@
+package ole
+
+import (
+	"fmt"
+	"testing"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+func TestComErrorHelpContext(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	info := excepInfoT{dwHelpContext: 1004, scode: 0x800A03EC}
+	err := ole.NewErrorWithSubError(dispEException, "", *(*ole.EXCEPINFO)(unsafe.Pointer(&info)))
+	if n := comError(L, err, "failed"); n != 2 {
+		t.Fatalf("comError pushed %d values", n)
+	}
+	e, ok := L.Get(-1).(*lua.LTable)
+	if !ok {
+		t.Fatalf("comError pushed %v", L.Get(-1))
+	}
+	if v := L.GetField(e, "helpcontext"); v != lua.LNumber(1004) {
+		t.Errorf("helpcontext=%v", v)
+	}
+	if v := L.GetField(e, "helpfile"); v != lua.LNil {
+		t.Errorf("helpfile=%v", v)
+	}
+	if v := L.GetField(e, "hex"); v != lua.LString("0x800A03EC") {
+		t.Errorf("hex=%v", v)
+	}
+}
+
+func TestComErrorKeepsHRESULT(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	// go-ole gives the zeroed EXCEPINFO for the failures other than
+	// DISP_E_EXCEPTION, and their HRESULTs have to be kept.
+	for _, hr := range []uintptr{dispEMemberNotFound, ole.E_INVALIDARG, dispEException} {
+		err := ole.NewErrorWithSubError(hr, "", ole.EXCEPINFO{})
+		comError(L, err, "failed")
+		e := L.Get(-1).(*lua.LTable)
+		L.Pop(2)
+		if v := L.GetField(e, "hex"); v != lua.LString(fmt.Sprintf("0x%08X", hr)) {
+			t.Errorf("comError(0x%08X): hex=%v", hr, v)
+		}
+		for _, name := range []string{"source", "description", "helpfile", "helpcontext"} {
+			if v := L.GetField(e, name); v != lua.LNil {
+				t.Errorf("comError(0x%08X): %s=%v for the empty EXCEPINFO", hr, name, v)
+			}
+		}
+	}
+}
+
+func TestPutRefUnsupported(t *testing.T) {
+	for _, c := range []struct {
+		hr     uintptr
+		expect bool
+	}{
+		{dispEMemberNotFound, true},
+		{dispETypeMismatch, true},
+		{ole.E_NOTIMPL, true},
+		{dispEException, false},
+		{ole.E_INVALIDARG, false},
+		{rpcECallRejected, false},
+	} {
+		if got := putRefUnsupported(ole.NewError(c.hr)); got != c.expect {
+			t.Errorf("putRefUnsupported(0x%08X)=%v (expected %v)", c.hr, got, c.expect)
+		}
+	}
+}
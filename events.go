@@ -0,0 +1,205 @@
+package ole
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+const connectionTypeName = "ole.connectionT"
+
+func connectionMeta(L *lua.LState) *lua.LTable {
+	if meta, ok := L.GetTypeMetatable(connectionTypeName).(*lua.LTable); ok {
+		return meta
+	}
+	meta := L.NewTypeMetatable(connectionTypeName)
+	L.SetField(meta, "__gc", L.NewFunction(disconnect))
+	L.SetField(meta, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"_disconnect": disconnect,
+	}))
+	return meta
+}
+
+// eventArgToLValue converts the argument of the event, which the caller
+// still owns, so the objects are referred by AddRef.
+func eventArgToLValue(L *lua.LState, v *ole.VARIANT) (lua.LValue, error) {
+	isObject := (v.VT == ole.VT_DISPATCH || v.VT == ole.VT_UNKNOWN) && v.Val != 0
+	if isObject {
+		v.ToIUnknown().AddRef()
+	}
+	val, err := variantToLValue(L, v)
+	if err != nil && isObject {
+		v.ToIUnknown().Release()
+	}
+	return val, err
+}
+
+// this:_connect("EVENTNAME",function(args...) ... end)
+// returns the connection whose _disconnect() stops the events.
+// The events are delivered while COM waits for messages
+// (for example, in PumpMessages) on the thread.
+func connectEvent(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_connect: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_connect: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_connect: "+p.nullError())
+	}
+	name, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "_connect: 2nd argument is not string")
+	}
+	fn, ok := L.Get(3).(*lua.LFunction)
+	if !ok {
+		return lerror(L, "_connect: 3rd argument is not a function")
+	}
+	conn, err := connect(p.Data, string(name), func(args []ole.VARIANT) {
+		L.Push(fn)
+		for i := range args {
+			val, err := eventArgToLValue(L, &args[i])
+			if err != nil {
+				val = lua.LNil
+			}
+			L.Push(val)
+		}
+		if err := L.PCall(len(args), 0, nil); err != nil {
+			logln(err)
+		}
+	})
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_connect: %s: %s", name, err.Error()))
+	}
+	cookie := L.NewUserData()
+	cookie.Value = conn
+	L.SetMetatable(cookie, connectionMeta(L))
+	L.Push(cookie)
+	return 1
+}
+
+// connection:_disconnect()
+func disconnect(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_disconnect: no receiver")
+	}
+	conn, ok := ud.Value.(*connectionT)
+	if !ok {
+		return lerror(L, "_disconnect: no receiver")
+	}
+	if err := conn.disconnect(); err != nil {
+		return lerror(L, fmt.Sprintf("_disconnect: %s", err.Error()))
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+const dispEException = 0x80020009
+
+// NewCallback returns the object (IDispatch) which calls the Lua function
+// for any member, so that it can be passed to the COM methods wanting
+// the callback objects. The arguments and the result are converted as
+// the methods' ones. The errors of the function are returned to
+// the caller as DISP_E_EXCEPTION with the message.
+func NewCallback(L *lua.LState) int {
+	fn, ok := L.Get(1).(*lua.LFunction)
+	if !ok {
+		return lerror(L, "NewCallback: parameter not a function")
+	}
+	disp := newCallback(func(args []ole.VARIANT, result *ole.VARIANT, excepInfo *excepInfoT) uintptr {
+		L.Push(fn)
+		for i := range args {
+			val, err := eventArgToLValue(L, &args[i])
+			if err != nil {
+				val = lua.LNil
+			}
+			L.Push(val)
+		}
+		if err := L.PCall(len(args), 1, nil); err != nil {
+			logln(err)
+			if excepInfo != nil {
+				excepInfo.bstrSource = (*uint16)(unsafe.Pointer(ole.SysAllocString("glua-ole")))
+				excepInfo.bstrDescription = (*uint16)(unsafe.Pointer(ole.SysAllocString(err.Error())))
+				excepInfo.scode = ole.E_FAIL
+			}
+			return dispEException
+		}
+		ret := L.Get(-1)
+		L.Pop(1)
+		if result == nil || ret == lua.LNil {
+			return ole.S_OK
+		}
+		value, err := lvalue2interface(L, ret)
+		if err != nil {
+			logln(err)
+			return ole.E_INVALIDARG
+		}
+		v, err := toVariant(value)
+		if err != nil {
+			logln(err)
+			return ole.E_INVALIDARG
+		}
+		// the caller frees the result.
+		*result = *v
+		return ole.S_OK
+	})
+	if disp == nil {
+		return lerror(L, "NewCallback: not supported on this platform")
+	}
+	L.Push(capsuleT{Data: disp}.ToLValue(L))
+	L.Push(lua.LNil)
+	return 2
+}
+
+// PumpMessages dispatches the window messages so that the events of STA
+// objects are delivered to the functions of _connect. It returns after
+// the timeout in milliseconds, or runs until WM_QUIT without it.
+// It returns false when WM_QUIT comes.
+func PumpMessages(L *lua.LState) int {
+	timeout := time.Duration(-1)
+	if ms, ok := L.Get(1).(lua.LNumber); ok {
+		timeout = time.Duration(float64(ms) * float64(time.Millisecond))
+	} else if L.Get(1) != lua.LNil {
+		return lerror(L, "PumpMessages: timeout is not a number")
+	}
+	L.Push(lua.LBool(pumpMessages(timeout)))
+	return 1
+}
+
+// RegisterMessageFilter installs IMessageFilter which retries the calls
+// rejected by the busy servers (SERVERCALL_RETRYLATER) until the timeout
+// in milliseconds (60 seconds by default), so that long automation of
+// Office does not fail while it is momentarily busy.
+// UnregisterMessageFilter restores the former filter.
+func RegisterMessageFilter(L *lua.LState) int {
+	timeout := time.Minute
+	if ms, ok := L.Get(1).(lua.LNumber); ok {
+		timeout = time.Duration(float64(ms) * float64(time.Millisecond))
+	} else if L.Get(1) != lua.LNil {
+		return lerror(L, "RegisterMessageFilter: timeout is not a number")
+	}
+	if initializedRequired {
+		initialize(ole.COINIT_APARTMENTTHREADED)
+	}
+	if err := registerMessageFilter(timeout); err != nil {
+		return lerror(L, fmt.Sprintf("RegisterMessageFilter: %s", err.Error()))
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// UnregisterMessageFilter removes the filter of RegisterMessageFilter.
+func UnregisterMessageFilter(L *lua.LState) int {
+	if err := unregisterMessageFilter(); err != nil {
+		return lerror(L, fmt.Sprintf("UnregisterMessageFilter: %s", err.Error()))
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
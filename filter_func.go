@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+import (
+	"time"
+
+	"github.com/go-ole/go-ole"
+)
+
+func registerMessageFilter(timeout time.Duration) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
+
+func unregisterMessageFilter() error {
+	return nil
+}
@@ -0,0 +1,150 @@
+package ole
+
+import (
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var (
+	procCoRegisterMessageFilter = modole32.NewProc("CoRegisterMessageFilter")
+
+	iidIMessageFilter = ole.NewGUID("{00000016-0000-0000-C000-000000000046}")
+)
+
+const (
+	serverCallIsHandled      = 0
+	serverCallRetryLater     = 2
+	pendingMsgWaitDefProcess = 2
+	retryCancel              = 0xFFFFFFFF
+)
+
+// filterT is the IMessageFilter implemented in Go which retries
+// the calls which the busy servers reject until the timeout.
+type filterT struct {
+	lpVtbl  *filterVtbl
+	ref     int32
+	timeout time.Duration
+	delay   time.Duration
+}
+
+type filterVtbl struct {
+	QueryInterface     uintptr
+	AddRef             uintptr
+	Release            uintptr
+	HandleInComingCall uintptr
+	RetryRejectedCall  uintptr
+	MessagePending     uintptr
+}
+
+var (
+	filterVtblOnce     sync.Once
+	filterVtblInstance *filterVtbl
+
+	// installedFilter keeps the registered filter from Go's GC
+	// and previousFilter is restored by unregisterMessageFilter.
+	installedFilter *filterT
+	previousFilter  *ole.IUnknown
+)
+
+func newFilterVtbl() *filterVtbl {
+	filterVtblOnce.Do(func() {
+		filterVtblInstance = &filterVtbl{
+			QueryInterface:     syscall.NewCallback(filterQueryInterface),
+			AddRef:             syscall.NewCallback(filterAddRef),
+			Release:            syscall.NewCallback(filterRelease),
+			HandleInComingCall: syscall.NewCallback(filterHandleInComingCall),
+			RetryRejectedCall:  syscall.NewCallback(filterRetryRejectedCall),
+			MessagePending:     syscall.NewCallback(filterMessagePending),
+		}
+	})
+	return filterVtblInstance
+}
+
+func filterQueryInterface(this *filterT, iid *ole.GUID, ppv *uintptr) uintptr {
+	if ole.IsEqualGUID(iid, ole.IID_IUnknown) || ole.IsEqualGUID(iid, iidIMessageFilter) {
+		filterAddRef(this)
+		*ppv = uintptr(unsafe.Pointer(this))
+		return ole.S_OK
+	}
+	*ppv = 0
+	return ole.E_NOINTERFACE
+}
+
+func filterAddRef(this *filterT) uintptr {
+	this.ref++
+	return uintptr(this.ref)
+}
+
+func filterRelease(this *filterT) uintptr {
+	this.ref--
+	return uintptr(this.ref)
+}
+
+func filterHandleInComingCall(this *filterT, callType, taskCaller, tickCount, interfaceInfo uintptr) uintptr {
+	return serverCallIsHandled
+}
+
+// filterRetryRejectedCall returns the milliseconds to wait before the retry,
+// or retryCancel to give up the call with RPC_E_CALL_REJECTED.
+func filterRetryRejectedCall(this *filterT, taskCallee, tickCount, rejectType uintptr) uintptr {
+	if rejectType != serverCallRetryLater {
+		return retryCancel
+	}
+	if time.Duration(uint32(tickCount))*time.Millisecond >= this.timeout {
+		return retryCancel
+	}
+	return uintptr(this.delay / time.Millisecond)
+}
+
+func filterMessagePending(this *filterT, taskCallee, tickCount, pendingType uintptr) uintptr {
+	return pendingMsgWaitDefProcess
+}
+
+// registerMessageFilter installs the filter which retries the rejected calls
+// for the timeout, replacing the filter installed by the former call.
+func registerMessageFilter(timeout time.Duration) error {
+	filter := &filterT{
+		lpVtbl:  newFilterVtbl(),
+		timeout: timeout,
+		// 100ms or more is the delay, and less is the immediate retry.
+		delay: 100 * time.Millisecond,
+	}
+	var old *ole.IUnknown
+	hr, _, _ := procCoRegisterMessageFilter.Call(
+		uintptr(unsafe.Pointer(filter)),
+		uintptr(unsafe.Pointer(&old)))
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	if installedFilter == nil {
+		previousFilter = old
+	}
+	// otherwise, the old one is our former filter which Go's GC frees.
+	installedFilter = filter
+	return nil
+}
+
+// unregisterMessageFilter restores the filter before registerMessageFilter.
+func unregisterMessageFilter() error {
+	if installedFilter == nil {
+		return nil
+	}
+	var old *ole.IUnknown
+	hr, _, _ := procCoRegisterMessageFilter.Call(
+		uintptr(unsafe.Pointer(previousFilter)),
+		uintptr(unsafe.Pointer(&old)))
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	if previousFilter != nil {
+		// COM refers it by its own AddRef again.
+		previousFilter.Release()
+	}
+	installedFilter = nil
+	previousFilter = nil
+	return nil
+}
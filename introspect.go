@@ -0,0 +1,190 @@
+package ole
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+// this:_dispid("MEMBERNAME")
+func dispid(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_dispid: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_dispid: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_dispid: "+p.nullError())
+	}
+	name, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "_dispid: 2nd argument is not string")
+	}
+	id, err := p.dispID(string(name))
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_dispid: %s: %s", name, err.Error()))
+	}
+	L.Push(lua.LNumber(id))
+	return 1
+}
+
+// this:_typename()
+func typename(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_typename: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_typename: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_typename: "+p.nullError())
+	}
+	name, err := typeName(p.Data)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_typename: type information is not available: %s", err.Error()))
+	}
+	L.Push(lua.LString(name))
+	return 1
+}
+
+type memberInfo struct {
+	Name   string
+	Kind   string // "method", "propget", "propput", "propputref" or "property"
+	DispID int32
+}
+
+// this:_methods() returns { {name=,kind=,dispid=}... }
+// A property with getter and setter appears twice as "propget" and "propput".
+func methods(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_methods: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_methods: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_methods: "+p.nullError())
+	}
+	members, err := typeMembers(p.Data)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_methods: type information is not available: %s", err.Error()))
+	}
+	result := L.NewTable()
+	for _, m := range members {
+		t := L.NewTable()
+		L.SetField(t, "name", lua.LString(m.Name))
+		L.SetField(t, "kind", lua.LString(m.Kind))
+		L.SetField(t, "dispid", lua.LNumber(m.DispID))
+		result.Append(t)
+	}
+	L.Push(result)
+	return 1
+}
+
+// this:_queryinterface("{IID}") returns the object of the interface.
+// The interface has to derive from IDispatch (dual or dispinterface).
+func queryInterface(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_queryinterface: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_queryinterface: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_queryinterface: "+p.nullError())
+	}
+	s, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "_queryinterface: 2nd argument is not string")
+	}
+	iid := ole.NewGUID(string(s))
+	if iid == nil {
+		return lerror(L, fmt.Sprintf("_queryinterface: %s: invalid GUID", s))
+	}
+	if ok, err := isDispatchable(p.Data, iid); !ok {
+		if err != nil {
+			return lerror(L, fmt.Sprintf("_queryinterface: %s: can not tell whether the interface supports IDispatch: %s", s, err.Error()))
+		}
+		return lerror(L, fmt.Sprintf("_queryinterface: %s: the interface does not support IDispatch", s))
+	}
+	unknown, err := p.Data.QueryInterface(iid)
+	if err != nil {
+		return comError(L, err, fmt.Sprintf("_queryinterface: %s: %s", s, err.Error()))
+	}
+	L.Push(capsuleT{Data: (*ole.IDispatch)(unsafe.Pointer(unknown))}.ToLValue(L))
+	return 1
+}
+
+// this:_supports("{IID}") tells whether QueryInterface succeeds for
+// the interface. The reference which QueryInterface returns is released.
+func supports(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_supports: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_supports: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_supports: "+p.nullError())
+	}
+	s, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "_supports: 2nd argument is not string")
+	}
+	iid := ole.NewGUID(string(s))
+	if iid == nil {
+		return lerror(L, fmt.Sprintf("_supports: %s: invalid GUID", s))
+	}
+	unknown, err := p.Data.QueryInterface(iid)
+	if err != nil {
+		L.Push(lua.LFalse)
+		return 1
+	}
+	unknown.Release()
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// this:_is_a("PROGID") tells whether the object is of the class of
+// the ProgID (or "{CLSID}"). The class of the object is read by IPersist
+// or IProvideClassInfo, and the objects without them are errors.
+func isA(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_is_a: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_is_a: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_is_a: "+p.nullError())
+	}
+	name, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "_is_a: 2nd argument is not string")
+	}
+	clsid, err := ole.ClassIDFrom(string(name))
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_is_a: %s: %s", name, err.Error()))
+	}
+	class, err := classID(p.Data)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_is_a: can not tell the class of the object: %s", err.Error()))
+	}
+	L.Push(lua.LBool(ole.IsEqualGUID(class, clsid)))
+	return 1
+}
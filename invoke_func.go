@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+import (
+	"github.com/go-ole/go-ole"
+)
+
+func invokeNamed(disp *ole.IDispatch, dispid int32, dispatch int16, args []ole.VARIANT, namedIDs []int32) (*ole.VARIANT, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
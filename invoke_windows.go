@@ -0,0 +1,65 @@
+package ole
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+// dispParams has the same layout as ole.DISPPARAMS whose fields are not exported.
+type dispParams struct {
+	rgvarg            uintptr
+	rgdispidNamedArgs uintptr
+	cArgs             uint32
+	cNamedArgs        uint32
+}
+
+// invokeNamed is IDispatch.Invoke with the named arguments, which go-ole
+// does not support. args are the positional arguments followed by
+// the named ones whose DISPIDs are namedIDs. The BSTRs of EXCEPINFO in
+// the error belong to the caller as ole.IDispatch.Invoke, and
+// comError (or freeExcepInfo) frees them.
+func invokeNamed(disp *ole.IDispatch, dispid int32, dispatch int16, args []ole.VARIANT, namedIDs []int32) (*ole.VARIANT, error) {
+	// DISPPARAMS has the named arguments first and then
+	// the positional ones in reverse order.
+	nNamed := len(namedIDs)
+	nPositional := len(args) - nNamed
+	vargs := make([]ole.VARIANT, len(args))
+	for i := 0; i < nNamed; i++ {
+		vargs[i] = args[nPositional+i]
+	}
+	for i := 0; i < nPositional; i++ {
+		vargs[nNamed+i] = args[nPositional-1-i]
+	}
+	var params dispParams
+	if len(vargs) > 0 {
+		params.rgvarg = uintptr(unsafe.Pointer(&vargs[0]))
+		params.cArgs = uint32(len(vargs))
+	}
+	if nNamed > 0 {
+		params.rgdispidNamedArgs = uintptr(unsafe.Pointer(&namedIDs[0]))
+		params.cNamedArgs = uint32(nNamed)
+	}
+
+	result := new(ole.VARIANT)
+	ole.VariantInit(result)
+	var excepInfo ole.EXCEPINFO
+	hr, _, _ := syscall.Syscall9(
+		disp.VTable().Invoke,
+		9,
+		uintptr(unsafe.Pointer(disp)),
+		uintptr(dispid),
+		uintptr(unsafe.Pointer(ole.IID_NULL)),
+		uintptr(ole.GetUserDefaultLCID()),
+		uintptr(dispatch),
+		uintptr(unsafe.Pointer(&params)),
+		uintptr(unsafe.Pointer(result)),
+		uintptr(unsafe.Pointer(&excepInfo)),
+		0)
+	if hr != 0 {
+		e := (*excepInfoT)(unsafe.Pointer(&excepInfo))
+		return nil, ole.NewErrorWithSubError(hr, ole.BstrToString(e.bstrDescription), excepInfo)
+	}
+	return result, nil
+}
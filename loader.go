@@ -0,0 +1,71 @@
+package ole
+
+import (
+	"github.com/yuin/gopher-lua"
+)
+
+var exports = map[string]lua.LGFunction{
+	"create_object":             CreateObject,
+	"get_object":                GetObject,
+	"get_active_object":         GetActiveObject,
+	"create_object_remote":      CreateObjectRemote,
+	"create_object_from_clsid":  CreateObjectFromCLSID,
+	"initialize":                Initialize,
+	"uninitialize":              Uninitialize,
+	"pairs":                     Pairs,
+	"with":                      With,
+	"new_callback":              NewCallback,
+	"register_message_filter":   RegisterMessageFilter,
+	"unregister_message_filter": UnregisterMessageFilter,
+	"pump_messages":             PumpMessages,
+	"to_ole_integer":            ToOleInteger,
+	"to_ole_int64":              ToOleInt64,
+	"to_ole_byte":               ToOleByte,
+	"to_ole_uint":               ToOleUInt,
+	"to_ole_string":             ToOleString,
+	"to_ole_bool":               ToOleBool,
+	"to_ole_date":               ToOleDate,
+	"to_ole_null":               ToOleNull,
+	"to_ole_empty":              ToOleEmpty,
+	"to_ole_missing":            ToOleMissing,
+	"to_ole_ref":                ToOleRef,
+	"to_ole_array":              ToOleArray,
+	"to_dictionary":             ToDictionary,
+}
+
+// constructors are the ToOle* functions by the short names.
+var constructors = map[string]lua.LGFunction{
+	"Integer": ToOleInteger,
+	"Int64":   ToOleInt64,
+	"Byte":    ToOleByte,
+	"UInt":    ToOleUInt,
+	"String":  ToOleString,
+	"Bool":    ToOleBool,
+	"Date":    ToOleDate,
+	"Null":    ToOleNull,
+	"Empty":   ToOleEmpty,
+	"Missing": ToOleMissing,
+	"Ref":     ToOleRef,
+	"Array":   ToOleArray,
+}
+
+// Constructors returns the table of the ToOle* functions by the short names
+// like `{Date=to_ole_date,Int64=to_ole_int64,Null=to_ole_null,...}`.
+func Constructors(L *lua.LState) int {
+	L.Push(L.SetFuncs(L.NewTable(), constructors))
+	return 1
+}
+
+// Loader is the module loader which returns the table of the functions.
+// It has the constructors too, so that `ole.Date(...)` works as `ole.to_ole_date(...)`.
+func Loader(L *lua.LState) int {
+	module := L.SetFuncs(L.NewTable(), exports)
+	L.Push(L.SetFuncs(module, constructors))
+	return 1
+}
+
+// Preload registers Loader to package.preload["ole"]
+// so that the scripts can do `local ole = require("ole")`.
+func Preload(L *lua.LState) {
+	L.PreloadModule("ole", Loader)
+}
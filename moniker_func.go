@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+import (
+	"github.com/go-ole/go-ole"
+)
+
+func bindToObject(name string) (*ole.IUnknown, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func loadFromFile(unknown *ole.IUnknown, path string) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
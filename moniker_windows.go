@@ -0,0 +1,49 @@
+package ole
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var iidIPersistFile = ole.NewGUID("{0000010B-0000-0000-C000-000000000046}")
+
+type iPersistFileVtbl struct {
+	ole.IUnknownVtbl
+	GetClassID    uintptr
+	IsDirty       uintptr
+	Load          uintptr
+	Save          uintptr
+	SaveCompleted uintptr
+	GetCurFile    uintptr
+}
+
+// bindToObject binds the display name (for example, a file path) by CoGetObject.
+func bindToObject(name string) (*ole.IUnknown, error) {
+	return ole.GetObject(name, nil, ole.IID_IUnknown)
+}
+
+// loadFromFile loads the file into the object by IPersistFile.Load.
+func loadFromFile(unknown *ole.IUnknown, path string) error {
+	persist, err := unknown.QueryInterface(iidIPersistFile)
+	if err != nil {
+		return err
+	}
+	defer persist.Release()
+	vtbl := (*iPersistFileVtbl)(unsafe.Pointer(persist.RawVTable))
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	hr, _, _ := syscall.Syscall(
+		vtbl.Load,
+		3,
+		uintptr(unsafe.Pointer(persist)),
+		uintptr(unsafe.Pointer(pathPtr)),
+		0) // STGM_READ
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
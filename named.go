@@ -0,0 +1,196 @@
+package ole
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+// toArgs converts the values made by lua2interface into the arguments of
+// invokeNamed. The VARIANTs made by lua2interface are passed by reference
+// as go-ole does. The caller has to Clear() the results.
+func toArgs(params []interface{}) ([]ole.VARIANT, error) {
+	args := make([]ole.VARIANT, len(params))
+	for i, param := range params {
+		if v, ok := param.(*ole.VARIANT); ok {
+			args[i] = ole.NewVariant(ole.VT_VARIANT|ole.VT_BYREF, int64(uintptr(unsafe.Pointer(v))))
+			continue
+		}
+		v, err := toVariant(param)
+		if err != nil {
+			clearArgs(args[:i])
+			return nil, err
+		}
+		args[i] = *v
+	}
+	return args, nil
+}
+
+// invokeParams is IDispatch.Invoke with the values made by lua2interface
+// like ole.IDispatch.Invoke but by invokeNamed.
+func invokeParams(disp *ole.IDispatch, dispid int32, dispatch int16, params []interface{}) (*ole.VARIANT, error) {
+	args, err := toArgs(params)
+	if err != nil {
+		return nil, err
+	}
+	defer clearArgs(args)
+	var namedIDs []int32
+	if dispatch&(ole.DISPATCH_PROPERTYPUT|ole.DISPATCH_PROPERTYPUTREF) != 0 && len(args) > 0 {
+		namedIDs = []int32{ole.DISPID_PROPERTYPUT}
+	}
+	return invokeNamed(disp, dispid, dispatch, args, namedIDs)
+}
+
+func clearArgs(args []ole.VARIANT) {
+	for i := range args {
+		args[i].Clear()
+	}
+}
+
+// this:_callnamed("METHODNAME",{positional...},{name=value,...})
+func callNamed(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_callnamed: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_callnamed: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_callnamed: "+p.nullError())
+	}
+	name, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "_callnamed: 2nd argument is not string")
+	}
+	var values []lua.LValue
+	if positional, ok := L.Get(3).(*lua.LTable); ok {
+		n, err := sequenceLen(positional)
+		if err != nil {
+			return lerror(L, fmt.Sprintf("_callnamed: %s", err.Error()))
+		}
+		for i := 1; i <= n; i++ {
+			values = append(values, positional.RawGetInt(i))
+		}
+	} else if L.Get(3) != lua.LNil {
+		return lerror(L, "_callnamed: 3rd argument is not a table")
+	}
+	names := []string{string(name)}
+	if named, ok := L.Get(4).(*lua.LTable); ok {
+		var err error
+		named.ForEach(func(key, value lua.LValue) {
+			s, ok := key.(lua.LString)
+			if !ok {
+				err = errors.New("the name of the argument is not a string")
+				return
+			}
+			names = append(names, string(s))
+			values = append(values, value)
+		})
+		if err != nil {
+			return lerror(L, "_callnamed: "+err.Error())
+		}
+	} else if L.Get(4) != lua.LNil {
+		return lerror(L, "_callnamed: 4th argument is not a table")
+	}
+	var ids []int32
+	var err error
+	if len(names) == 1 {
+		var id int32
+		id, err = p.dispID(string(name))
+		ids = []int32{id}
+	} else {
+		// the DISPIDs of the arguments belong to the method,
+		// so they are not cached.
+		ids, err = p.Data.GetIDsOfName(names)
+	}
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_callnamed: %s: %s", name, err.Error()))
+	}
+
+	params := make([]interface{}, len(values))
+	for i, value := range values {
+		params[i], err = lvalue2interface(L, value)
+		if err != nil {
+			freeParams(params)
+			return lerror(L, fmt.Sprintf("_callnamed: %s", err.Error()))
+		}
+	}
+	defer freeParams(params)
+	args, err := toArgs(params)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_callnamed: %s", err.Error()))
+	}
+	defer clearArgs(args)
+
+	result, err := retryBusy(func() (*ole.VARIANT, error) {
+		return invokeNamed(p.Data, ids[0], ole.DISPATCH_METHOD, args, ids[1:])
+	})
+	if err != nil {
+		return comError(L, err, fmt.Sprintf("_callnamed(%s): %s", name, err.Error()))
+	}
+	val, err := resultToLValue(L, result)
+	if err != nil {
+		return lerror(L, err.Error())
+	}
+	L.Push(val)
+	return 1
+}
+
+var invokeFlags = map[string]int16{
+	"method":     ole.DISPATCH_METHOD,
+	"propget":    ole.DISPATCH_PROPERTYGET,
+	"propput":    ole.DISPATCH_PROPERTYPUT,
+	"propputref": ole.DISPATCH_PROPERTYPUTREF,
+}
+
+// this:_invoke(DISPID,FLAGS,params...) calls IDispatch.Invoke with FLAGS
+// which is the number of DISPATCH_* or "method","propget","propput" and
+// "propputref" as the kinds of _methods.
+func invokeByDispID(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_invoke: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_invoke: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_invoke: "+p.nullError())
+	}
+	id, ok := L.Get(2).(lua.LNumber)
+	if !ok {
+		return lerror(L, "_invoke: 2nd argument is not a DISPID")
+	}
+	var flags int16
+	switch f := L.Get(3).(type) {
+	case lua.LNumber:
+		flags = int16(f)
+	case lua.LString:
+		if flags, ok = invokeFlags[string(f)]; !ok {
+			return lerror(L, fmt.Sprintf("_invoke: %s: unknown flags", f))
+		}
+	default:
+		return lerror(L, "_invoke: 3rd argument is not flags")
+	}
+	params, err := lua2interfaceS(L, 4, L.GetTop())
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_invoke: %s", err.Error()))
+	}
+	defer freeParams(params)
+	result, err := p.invoke(int32(id), flags, params)
+	if err != nil {
+		return comError(L, err, fmt.Sprintf("_invoke(%d): %s", int32(id), err.Error()))
+	}
+	val, err := resultToLValue(L, result)
+	if err != nil {
+		return lerror(L, err.Error())
+	}
+	L.Push(val)
+	return 1 + pushRefParams(L, 4, params)
+}
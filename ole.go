@@ -1,10 +1,18 @@
 package ole
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"os"
+	"io"
+	"math"
+	"math/big"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unsafe"
 
 	"github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
@@ -13,26 +21,336 @@ import (
 
 var initializedRequired = true
 
+// initializedModel is the flags (with the threading model) of the initialized COM.
+var initializedModel uint32 = ole.COINIT_APARTMENTTHREADED
+
+// CurrencyAsString makes VT_CY values converted into Lua strings like
+// "1234.5678" instead of Lua numbers. VT_CY is a 64-bit integer scaled
+// by 10000, so a Lua number (float64) can lose the lower digits of
+// large amounts and can not always hold the four decimals exactly.
+var CurrencyAsString = false
+
+// DecimalAsString makes VT_DECIMAL values converted into Lua strings
+// which keep all 96-bit digits. When false, they are converted into
+// Lua numbers (float64) which are convenient but lossy.
+var DecimalAsString = false
+
+// LargeIntegerAsString makes the integers beyond 2^53, which float64 can not
+// hold exactly (for example, VT_I8 file sizes), converted into Lua strings
+// like "9007199254740993". to_ole_int64 accepts them.
+var LargeIntegerAsString = false
+
+// maxSafeInteger is the largest integer n where float64 holds 1..n exactly.
+const maxSafeInteger = 1 << 53
+
+// VariantHook customizes the conversion of the results (and the elements
+// of the arrays) into the Lua values, for example, to make VT_DATE ISO 8601
+// strings. It is called before the default conversion, and the value which
+// it returns wins unless it is nil. For VT_DISPATCH and VT_UNKNOWN, the
+// hook returning a value takes the reference of v as the default does.
+var VariantHook func(L *lua.LState, v *ole.VARIANT) lua.LValue
+
+// ParamHook customizes the conversion of the Lua values into the parameters
+// (and the elements of the tables sent as arrays), for example, to send
+// the tables like {currency=12.34} as VT_CY. It is called before the default
+// conversion, and when it returns true, its value is used: nil, bool,
+// string, float64, int, int64, uint32, *ole.IDispatch or *ole.VARIANT
+// (which is cleared after the call).
+var ParamHook func(L *lua.LState, value lua.LValue) (interface{}, bool)
+
 type capsuleT struct {
-	Data *ole.IDispatch
+	Data     *ole.IDispatch
+	dispIDs  *dispIDCache
+	released bool
+	// addRefs is the count of the references added by _addref,
+	// which are released with the capsule's own one.
+	addRefs int32
+}
+
+// dispIDCache keeps DISPIDs of the object to skip GetIDsOfNames.
+// DISPIDs are stable per object, so they are never invalidated.
+type dispIDCache struct {
+	sync.Mutex
+	ids map[string]int32
+}
+
+func (c *capsuleT) release() {
+	if c.Data != nil {
+		for ; c.addRefs > 0; c.addRefs-- {
+			c.Data.Release()
+		}
+		c.Data.Release()
+		c.Data = nil
+		c.released = true
+	}
+}
+
+func (c *capsuleT) dispID(name string) (int32, error) {
+	if c.dispIDs == nil {
+		return c.Data.GetSingleIDOfName(name)
+	}
+	c.dispIDs.Lock()
+	defer c.dispIDs.Unlock()
+	if id, ok := c.dispIDs.ids[name]; ok {
+		return id, nil
+	}
+	id, err := c.Data.GetSingleIDOfName(name)
+	if err != nil {
+		return 0, err
+	}
+	c.dispIDs.ids[name] = id
+	return id, nil
+}
+
+func (c *capsuleT) invoke(id int32, dispatch int16, params []interface{}) (*ole.VARIANT, error) {
+	for _, p := range params {
+		if _, ok := p.(missingT); ok {
+			return retryBusy(func() (*ole.VARIANT, error) {
+				return invokeParams(c.Data, id, dispatch, params)
+			})
+		}
+	}
+	return retryBusy(func() (*ole.VARIANT, error) {
+		return c.Data.Invoke(id, dispatch, params...)
+	})
+}
+
+// RetryCount is the count of the retries of the calls which the busy servers
+// reject with RPC_E_CALL_REJECTED or RPC_E_SERVERCALL_RETRYLATER (e.g., Excel
+// showing a modal dialog). The first retry waits RetryDelay, and the delay
+// doubles on each retry. No retries are done by default.
+var RetryCount = 0
+
+// RetryDelay is the first delay of the retries of RetryCount.
+var RetryDelay = 100 * time.Millisecond
+
+const (
+	rpcECallRejected         = 0x80010001
+	rpcEServerCallRetryLater = 0x8001010A
+)
+
+func retryBusy(call func() (*ole.VARIANT, error)) (*ole.VARIANT, error) {
+	delay := RetryDelay
+	for i := 0; ; i++ {
+		result, err := call()
+		if err == nil || i >= RetryCount {
+			return result, err
+		}
+		code, ok := oleErrorCode(err)
+		if !ok || (code != rpcECallRejected && code != rpcEServerCallRetryLater) {
+			return result, err
+		}
+		logln("retry after", delay, ":", err.Error())
+		freeExcepInfo(err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// CallMethodByDispID is IDispatch.CallMethod with the cached DISPID.
+func (c *capsuleT) CallMethodByDispID(name string, params ...interface{}) (*ole.VARIANT, error) {
+	id, err := c.dispID(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.invoke(id, ole.DISPATCH_METHOD, params)
+}
+
+// GetPropertyByDispID is IDispatch.GetProperty with the cached DISPID.
+func (c *capsuleT) GetPropertyByDispID(name string, params ...interface{}) (*ole.VARIANT, error) {
+	id, err := c.dispID(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.invoke(id, ole.DISPATCH_PROPERTYGET, params)
+}
+
+// PutPropertyByDispID is IDispatch.PutProperty with the cached DISPID.
+func (c *capsuleT) PutPropertyByDispID(name string, params ...interface{}) (*ole.VARIANT, error) {
+	id, err := c.dispID(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.invoke(id, ole.DISPATCH_PROPERTYPUT, params)
+}
+
+const (
+	// releasedError is the error for the objects used after _release().
+	releasedError = "object already released"
+	// nullObjectError is the error for the null objects, for example,
+	// OBJ.member.member whose middle is not an object.
+	nullObjectError = "operation on null COM object"
+)
+
+func (c *capsuleT) nullError() string {
+	if c.released {
+		return releasedError
+	}
+	return nullObjectError
 }
 
 type methodT struct {
-	Name string
-	Data *ole.IDispatch
+	Name  string
+	Data  *ole.IDispatch
+	owner *capsuleT
+	// temporary means that the owner is an intermediate object of
+	// `OBJ.member.member` made by get2. Nobody but the methodT refers to it,
+	// so it is released when the methodT is used.
+	temporary bool
+	// indexed is the member before Name which needs the arguments
+	// (`OBJ.INDEXED.Name(args)`), so it is evaluated by the call.
+	indexed string
 }
 
-func (c capsuleT) ToLValue(L *lua.LState) lua.LValue {
-	ud := L.NewUserData()
-	ud.Value = &c
-	meta := L.NewTable()
+// release releases the owner if it is an intermediate object.
+func (m *methodT) release() {
+	if m.temporary && m.owner != nil {
+		m.owner.release()
+		m.Data = nil
+	}
+}
+
+// value reads the member as the property. The caller has to Clear()
+// the result.
+func (m *methodT) value() (*ole.VARIANT, error) {
+	owner := m.owner
+	if owner == nil {
+		owner = &capsuleT{Data: m.Data}
+	}
+	if owner.Data == nil {
+		return nil, errors.New(owner.nullError())
+	}
+	defer m.release()
+	if m.indexed != "" {
+		return nil, fmt.Errorf("%s needs the arguments", m.indexed)
+	}
+	return owner.GetPropertyByDispID(m.Name)
+}
+
+// resolve reads the member as the object for OBJ.member.member = value.
+// The caller has to release the result.
+func (m *methodT) resolve() (*capsuleT, error) {
+	result, err := m.value()
+	if err != nil {
+		return nil, err
+	}
+	if result.VT != ole.VT_DISPATCH || result.Val == 0 {
+		result.Clear()
+		return nil, errors.New(nullObjectError)
+	}
+	return &capsuleT{Data: result.ToIDispatch()}, nil
+}
+
+const (
+	capsuleTypeName    = "ole.capsuleT"
+	methodTypeName     = "ole.methodT"
+	enumeratorTypeName = "ole.enumeratorT"
+)
+
+// capsuleMeta returns the metatable of capsuleT
+// which is made only once per lua.LState and kept in the registry.
+func capsuleMeta(L *lua.LState) *lua.LTable {
+	if meta, ok := L.GetTypeMetatable(capsuleTypeName).(*lua.LTable); ok {
+		return meta
+	}
+	meta := L.NewTypeMetatable(capsuleTypeName)
 	L.SetField(meta, "__gc", L.NewFunction(gc))
 	L.SetField(meta, "__index", L.NewFunction(index))
 	L.SetField(meta, "__newindex", L.NewFunction(set))
-	L.SetMetatable(ud, meta)
+	L.SetField(meta, "__tostring", L.NewFunction(tostring))
+	L.SetField(meta, "__len", L.NewFunction(length))
+	L.SetField(meta, "__pairs", L.NewFunction(pairs))
+	L.SetField(meta, "__call", L.NewFunction(callDefault))
+	return meta
+}
+
+func (c capsuleT) ToLValue(L *lua.LState) lua.LValue {
+	if c.dispIDs == nil {
+		c.dispIDs = &dispIDCache{ids: map[string]int32{}}
+	}
+	track(L, &c)
+	ud := L.NewUserData()
+	ud.Value = &c
+	L.SetMetatable(ud, capsuleMeta(L))
 	return ud
 }
 
+// methodMeta returns the metatable of methodT like capsuleMeta.
+func methodMeta(L *lua.LState) *lua.LTable {
+	if meta, ok := L.GetTypeMetatable(methodTypeName).(*lua.LTable); ok {
+		return meta
+	}
+	meta := L.NewTypeMetatable(methodTypeName)
+	L.SetField(meta, "__newindex", L.NewFunction(set))
+	L.SetField(meta, "__call", L.NewFunction(call2))
+	L.SetField(meta, "__index", L.NewFunction(get2))
+	return meta
+}
+
+// length returns the property "Count" for `#OBJ`.
+// Lua ignores the error values of __len, so it raises errors.
+func length(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		L.RaiseError("length: not a userdata")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		L.RaiseError("length: not a capsuleT")
+	}
+	if p.Data == nil {
+		L.RaiseError("length: %s", p.nullError())
+	}
+	result, err := p.GetPropertyByDispID("Count")
+	if err != nil {
+		L.RaiseError("length: the object has no Count: %s", err.Error())
+	}
+	val, err := resultToLValue(L, result)
+	if err != nil {
+		L.RaiseError("length: %s", err.Error())
+	}
+	n, ok := val.(lua.LNumber)
+	if !ok {
+		L.RaiseError("length: Count is not a number")
+	}
+	L.Push(n)
+	return 1
+}
+
+// tostring returns the default property (DISPID_VALUE) if it is a scalar,
+// otherwise the interface name and the address.
+func tostring(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "tostring: not a userdata")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "tostring: not a capsuleT")
+	}
+	if p.Data == nil {
+		L.Push(lua.LString("ole.IDispatch(nil)"))
+		return 1
+	}
+	if result, err := p.invoke(ole.DISPID_VALUE, ole.DISPATCH_PROPERTYGET, nil); err == nil {
+		if result.VT != ole.VT_DISPATCH && result.VT != ole.VT_UNKNOWN && result.VT&ole.VT_ARRAY == 0 {
+			val, err := variantToLValue(L, result)
+			if err == nil && val != lua.LNil {
+				result.Clear()
+				L.Push(lua.LString(val.String()))
+				return 1
+			}
+		}
+		result.Clear()
+	}
+	name, err := typeName(p.Data)
+	if err != nil || name == "" {
+		name = "IDispatch"
+	}
+	L.Push(lua.LString(fmt.Sprintf("ole.%s(%p)", name, p.Data)))
+	return 1
+}
+
 func gc(L *lua.LState) int {
 	const noReceiverErr = "gc: no receiver"
 	if L.GetTop() < 1 {
@@ -46,17 +364,81 @@ func gc(L *lua.LState) int {
 	if !ok {
 		return lerror(L, noReceiverErr)
 	}
-	if p.Data != nil {
-		// println("COM released")
-		p.Data.Release()
-		p.Data = nil
-	}
+	p.release()
 	L.Push(lua.LTrue)
 	return 1
 }
 
+// this:_clone() returns the new object which refers the same COM object
+// by AddRef. It is independent of the original, so each of them needs
+// its own _release().
+// this:_addref() calls AddRef for the diagnostics and returns the count
+// which AddRef returns. The added references are released by _release().
+func addRef(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_addref: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_addref: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_addref: "+p.nullError())
+	}
+	p.addRefs++
+	L.Push(lua.LNumber(p.Data.AddRef()))
+	return 1
+}
+
+// this:_refcount() returns the count which Release returns after AddRef.
+// COM does not define the counts as observable values: the proxies of
+// the out-of-process servers count their own references, and some
+// objects return constants. It is approximate and for debugging only.
+func refCount(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_refcount: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_refcount: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_refcount: "+p.nullError())
+	}
+	p.Data.AddRef()
+	L.Push(lua.LNumber(p.Data.Release()))
+	return 1
+}
+
+func clone(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_clone: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_clone: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_clone: "+p.nullError())
+	}
+	p.Data.AddRef()
+	L.Push(capsuleT{Data: p.Data, dispIDs: p.dispIDs}.ToLValue(L))
+	return 1
+}
+
 func lua2interface(L *lua.LState, index int) (interface{}, error) {
-	valueTmp := L.Get(index)
+	return lvalue2interface(L, L.Get(index))
+}
+
+func lvalue2interface(L *lua.LState, valueTmp lua.LValue) (interface{}, error) {
+	if ParamHook != nil {
+		if value, ok := ParamHook(L, valueTmp); ok {
+			return value, nil
+		}
+	}
 	if valueTmp == lua.LNil {
 		return nil, nil
 	} else if valueTmp == lua.LTrue {
@@ -68,16 +450,57 @@ func lua2interface(L *lua.LState, index int) (interface{}, error) {
 	default:
 		return nil, errors.New("lua2interface: not support type")
 	case lua.LString:
+		// go-ole and toVariant make BSTR by SysAllocStringLen,
+		// which encodes the UTF-8 string into UTF-16 by the runes.
 		return string(value), nil
 	case lua.LNumber:
 		return float64(value), nil
+	case *lua.LTable:
+		return tableToVariant(L, value)
 	case *lua.LUserData:
-		if v, ok := value.Value.(int); ok {
-			return int(v), nil
+		if p, ok := value.Value.(*oleParam); ok {
+			return p.param(), nil
 		}
 		if c, ok := value.Value.(*capsuleT); ok {
+			if c.Data == nil {
+				return nil, errors.New("lua2interface: " + c.nullError())
+			}
 			return c.Data, nil
 		}
+		if m, ok := value.Value.(*methodT); ok {
+			// OBJ.member.member given as a parameter is the property value.
+			// The VARIANT is freed by freeParams with the other parameters.
+			v, err := m.value()
+			if err != nil {
+				return nil, err
+			}
+			return v, nil
+		}
+		if t, ok := value.Value.(time.Time); ok {
+			if t.IsZero() {
+				v := ole.NewVariant(ole.VT_EMPTY, 0)
+				return &v, nil
+			}
+			v := ole.NewVariant(ole.VT_DATE, int64(math.Float64bits(timeToOleDate(t))))
+			return &v, nil
+		}
+		if a, ok := value.Value.(*numberArrayT); ok {
+			return a.toVariant()
+		}
+		if a, ok := value.Value.(*variantArrayT); ok {
+			return tableToVariantLower(L, a.table, a.lower)
+		}
+		if r, ok := value.Value.(*refT); ok {
+			if r.Value == lua.LNil {
+				v := ole.NewVariant(ole.VT_EMPTY, 0)
+				return &v, nil
+			}
+			inner, err := lvalue2interface(L, r.Value)
+			if err != nil {
+				return nil, err
+			}
+			return toVariant(inner)
+		}
 		return nil, errors.New("lua2interface: not a OBJECT")
 	}
 }
@@ -87,6 +510,7 @@ func lua2interfaceS(L *lua.LState, start, end int) ([]interface{}, error) {
 	for i := start; i <= end; i++ {
 		val, err := lua2interface(L, i)
 		if err != nil {
+			freeParams(result)
 			return nil, err
 		}
 		result[i-start] = val
@@ -94,6 +518,52 @@ func lua2interfaceS(L *lua.LState, start, end int) ([]interface{}, error) {
 	return result, nil
 }
 
+// freeParams releases the VARIANTs which lua2interface allocated
+// (for example, SAFEARRAY made from Lua tables)
+func freeParams(params []interface{}) {
+	for _, p := range params {
+		if v, ok := p.(*ole.VARIANT); ok && v != nil {
+			v.Clear()
+		}
+	}
+}
+
+// toVariant converts the value made by lua2interface into a VARIANT.
+// The caller has to Clear() the result.
+func toVariant(value interface{}) (*ole.VARIANT, error) {
+	var v ole.VARIANT
+	switch value := value.(type) {
+	case nil:
+		v = ole.NewVariant(ole.VT_NULL, 0)
+	case bool:
+		if value {
+			v = ole.NewVariant(ole.VT_BOOL, 0xffff)
+		} else {
+			v = ole.NewVariant(ole.VT_BOOL, 0)
+		}
+	case string:
+		v = ole.NewVariant(ole.VT_BSTR, int64(uintptr(unsafe.Pointer(ole.SysAllocStringLen(value)))))
+	case float64:
+		v = ole.NewVariant(ole.VT_R8, int64(math.Float64bits(value)))
+	case int:
+		v = ole.NewVariant(ole.VT_I4, int64(value))
+	case int64:
+		v = ole.NewVariant(ole.VT_I8, value)
+	case uint32:
+		v = ole.NewVariant(ole.VT_UI4, int64(value))
+	case missingT:
+		v = ole.NewVariant(ole.VT_ERROR, dispEParamNotFound)
+	case *ole.IDispatch:
+		value.AddRef()
+		v = ole.NewVariant(ole.VT_DISPATCH, int64(uintptr(unsafe.Pointer(value))))
+	case *ole.VARIANT:
+		return value, nil
+	default:
+		return nil, fmt.Errorf("toVariant: %T: not support type", value)
+	}
+	return &v, nil
+}
+
 // this:_call("METHODNAME",params...)
 func call1(L *lua.LState) int {
 	ud, ok := L.Get(1).(*lua.LUserData)
@@ -104,11 +574,49 @@ func call1(L *lua.LState) int {
 	if !ok {
 		return lerror(L, "call1: not found capsuleT")
 	}
+	if p.Data == nil {
+		return lerror(L, "call1: "+p.nullError())
+	}
 	name, ok := L.Get(2).(lua.LString)
 	if !ok {
 		return lerror(L, "call1: not found methodname")
 	}
-	return callCommon(L, p.Data, string(name))
+	return callCommon(L, p, string(name))
+}
+
+// this:_apply("METHODNAME",{params...}) calls the method with the elements
+// of the table as the parameters like this:METHODNAME(table.unpack(params)).
+func apply(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_apply: not found object")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_apply: not found capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_apply: "+p.nullError())
+	}
+	name, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "_apply: not found methodname")
+	}
+	args, ok := L.Get(3).(*lua.LTable)
+	if !ok && L.Get(3) != lua.LNil {
+		return lerror(L, "_apply: 3rd argument is not a table")
+	}
+	L.SetTop(2)
+	if args != nil {
+		n, err := sequenceLen(args)
+		if err != nil {
+			return lerror(L, fmt.Sprintf("_apply: %s", err.Error()))
+		}
+		for i := 1; i <= n; i++ {
+			L.Push(args.RawGetInt(i))
+		}
+	}
+	return callCommon(L, p, string(name))
 }
 
 // this:METHODNAME(params...)
@@ -121,6 +629,9 @@ func call2(L *lua.LState) int {
 	if !ok || method.Name == "" {
 		return lerror(L, "call2: not found methodT")
 	}
+	if method.indexed != "" {
+		return callIndexed(L, method)
+	}
 	ud, ok = L.Get(2).(*lua.LUserData)
 	if !ok {
 		return lerror(L, "call2: not found userdata for object_t")
@@ -128,47 +639,146 @@ func call2(L *lua.LState) int {
 	obj, ok := ud.Value.(*capsuleT)
 	if !ok {
 		if method.Data == nil {
-			return lerror(L, "call2: receiver is not found")
+			return lerror(L, fmt.Sprintf("call2: %s: %s", method.Name, nullObjectError))
 		}
-		return callCommon(L, method.Data, method.Name)
+		if method.owner != nil {
+			defer method.release()
+			return callCommon(L, method.owner, method.Name)
+		}
+		return callCommon(L, &capsuleT{Data: method.Data}, method.Name)
 		// this code enables `OLEOBJ.PROPERTY.PROPERTY:METHOD()`
 	}
 	if obj.Data == nil {
-		return lerror(L, "call2: OLEOBJECT(): the receiver is null")
+		return lerror(L, "call2: "+obj.nullError())
+	}
+	return callCommon(L, obj, method.Name)
+}
+
+// callIndexed calls `OBJ.INDEXED.member(args)` and `OBJ.INDEXED:member(args)`
+// as `OBJ:INDEXED(args):member()`.
+func callIndexed(L *lua.LState, m *methodT) int {
+	defer m.release()
+	owner := m.owner
+	if owner == nil {
+		owner = &capsuleT{Data: m.Data}
+	}
+	if owner.Data == nil {
+		return lerror(L, fmt.Sprintf("call2: %s: %s", m.indexed, owner.nullError()))
+	}
+	start := 2
+	if ud, ok := L.Get(2).(*lua.LUserData); ok {
+		if _, ok := ud.Value.(*methodT); ok {
+			start = 3
+		}
+	}
+	params, err := lua2interfaceS(L, start, L.GetTop())
+	if err != nil {
+		return lerror(L, fmt.Sprintf("call2: %s", err.Error()))
+	}
+	defer freeParams(params)
+	result, err := owner.GetPropertyByDispID(m.indexed, params...)
+	if err != nil {
+		return comError(L, err, fmt.Sprintf("oleutil.GetProperty(%s): %s", m.indexed, err.Error()))
+	}
+	if result.VT != ole.VT_DISPATCH || result.Val == 0 {
+		result.Clear()
+		return lerror(L, fmt.Sprintf("call2: %s: %s", m.Name, nullObjectError))
 	}
-	return callCommon(L, obj.Data, method.Name)
+	obj := &capsuleT{Data: result.ToIDispatch()}
+	defer obj.release()
+	L.SetTop(2)
+	return callCommon(L, obj, m.Name)
 }
 
-func callCommon(L *lua.LState, com1 *ole.IDispatch, name string) int {
+func callCommon(L *lua.LState, com1 *capsuleT, name string) int {
+	if com1.Data == nil {
+		return lerror(L, fmt.Sprintf("callCommon: %s: %s", name, com1.nullError()))
+	}
 	count := L.GetTop()
 	params, err := lua2interfaceS(L, 3, count)
 	if err != nil {
 		return lerror(L, fmt.Sprintf("callCommon: %s", err.Error()))
 	}
-	result, err := com1.CallMethod(name, params...)
+	defer freeParams(params)
+	result, err := com1.CallMethodByDispID(name, params...)
 	if err != nil {
-		return lerror(L, fmt.Sprintf("oleutil.CallMethod(%s): %s", name, err.Error()))
+		return comError(L, err, fmt.Sprintf("oleutil.CallMethod(%s): %s", name, err.Error()))
 	}
-	val, err := variantToLValue(L, result)
+	val, err := resultToLValue(L, result)
 	if err == nil {
 		L.Push(val)
-		return 1
+		return 1 + pushRefParams(L, 3, params)
 	} else {
-		L.Push(lua.LNil)
-		L.Push(lua.LString(err.Error()))
-		fmt.Fprintln(os.Stderr, err)
-		return 2
+		return lerror(L, err.Error())
+	}
+}
+
+// pushRefParams pushes the values which the method stored into
+// the parameters made by ToOleRef, in order of the parameters.
+// It returns the count of pushed values.
+func pushRefParams(L *lua.LState, start int, params []interface{}) int {
+	count := 0
+	for i, p := range params {
+		ud, ok := L.Get(start + i).(*lua.LUserData)
+		if !ok {
+			continue
+		}
+		if _, ok := ud.Value.(*refT); !ok {
+			continue
+		}
+		v, ok := p.(*ole.VARIANT)
+		if !ok {
+			continue
+		}
+		val, err := variantToLValue(L, v)
+		if err != nil {
+			// v still has it, which freeParams frees.
+			val = lua.LNil
+		} else if v.VT == ole.VT_DISPATCH || v.VT == ole.VT_UNKNOWN {
+			// the reference was moved to val and must not be freed.
+			*v = ole.VARIANT{}
+		}
+		L.Push(val)
+		count++
 	}
+	return count
 }
 
 func set(L *lua.LState) int {
+	return setSub(L, false)
+}
+
+// SetReturnsPrevious makes OBJ:_set(...) read the property before setting it
+// and return the previous value as the third result after true and nil.
+// It costs one more call of COM per _set. `OBJ.PROPERTY = value` does not read.
+var SetReturnsPrevious = false
+
+// this:_set("NAME",key...,value) with SetReturnsPrevious
+func setPrevious(L *lua.LState) int {
+	return setSub(L, true)
+}
+
+func setSub(L *lua.LState, withPrevious bool) int {
 	ud, ok := L.Get(1).(*lua.LUserData)
 	if !ok {
 		return lerror(L, "set: the 1st argument is not usedata")
 	}
 	p, ok := ud.Value.(*capsuleT)
 	if !ok {
-		return lerror(L, "set: the 1st argument is not *capsuleT")
+		m, ok := ud.Value.(*methodT)
+		if !ok {
+			return lerror(L, "set: the 1st argument is not *capsuleT")
+		}
+		// OBJ.member.member = value sets the property of the member's object.
+		var err error
+		p, err = m.resolve()
+		if err != nil {
+			return comError(L, err, fmt.Sprintf("set: %s: %s", m.Name, err.Error()))
+		}
+		defer p.release()
+	}
+	if p.Data == nil {
+		return lerror(L, "set: "+p.nullError())
 	}
 	name, ok := L.Get(2).(lua.LString)
 	if !ok {
@@ -178,54 +788,213 @@ func set(L *lua.LState) int {
 	if err != nil {
 		return lerror(L, fmt.Sprintf("set: %s", err.Error()))
 	}
-	p.Data.PutProperty(string(name), key...)
+	defer freeParams(key)
+	var previous lua.LValue = lua.LNil
+	if withPrevious && len(key) > 0 {
+		// write-only properties have no previous values.
+		if old, err := p.GetPropertyByDispID(string(name), key[:len(key)-1]...); err == nil {
+			if val, err := resultToLValue(L, old); err == nil {
+				previous = val
+			}
+		}
+	}
+	result, err := p.putProperty(string(name), key)
+	if err != nil {
+		return comError(L, err, fmt.Sprintf("set: %s: %s", name, err.Error()))
+	}
+	result.Clear()
 	L.Push(lua.LTrue)
 	L.Push(lua.LNil)
+	if withPrevious {
+		L.Push(previous)
+		return 3
+	}
 	return 2
 }
 
-type enumeratorT struct {
-	newEnum *ole.VARIANT
-	enum    *ole.IEnumVARIANT
+// putProperty sets the last of params to the property with the others as
+// the indices like `OBJ.PROPERTY(index...) = value`. go-ole puts the last
+// one into rgvarg[0] named DISPID_PROPERTYPUT, as COM requires.
+// It uses DISPATCH_PROPERTYPUTREF for an object as VBScript's `Set`, and
+// DISPATCH_PROPERTYPUT when the server does not support it. The other
+// failures of PUTREF are returned without PUT not to assign twice.
+func (c *capsuleT) putProperty(name string, params []interface{}) (*ole.VARIANT, error) {
+	id, err := c.dispID(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) > 0 && isObjectParam(params[len(params)-1]) {
+		result, err := c.invoke(id, ole.DISPATCH_PROPERTYPUTREF, params)
+		if err == nil || !putRefUnsupported(err) {
+			return result, err
+		}
+		freeExcepInfo(err)
+	}
+	if len(params) > 0 && isArrayParam(params[len(params)-1]) {
+		return c.putArray(id, params)
+	}
+	return c.invoke(id, ole.DISPATCH_PROPERTYPUT, params)
 }
 
-func (e *enumeratorT) Close() error {
-	e.enum.Release()
-	e.newEnum.Clear()
-	return nil
+// putRefUnsupported tells whether the error is from the server which
+// does not support DISPATCH_PROPERTYPUTREF for the property.
+func putRefUnsupported(err error) bool {
+	code, ok := oleErrorCode(err)
+	return ok && (code == dispEMemberNotFound || code == dispETypeMismatch || code == ole.E_NOTIMPL)
 }
 
-func iterGc(L *lua.LState) int {
-	ud, ok := L.Get(1).(*lua.LUserData)
-	if !ok {
-		return 0
-	}
-	if ud.Value == nil {
-		return 0
-	}
-	e, ok := ud.Value.(*enumeratorT)
-	if !ok {
-		return 0
+// putArray puts the SAFEARRAY made from the Lua table as the VARIANT itself
+// instead of VT_VARIANT|VT_BYREF, as VBScript does for
+// `range.Value = array`, because some servers do not dereference the
+// arrays of the puts. The array still belongs to params, and the caller
+// frees it after the assignment.
+func (c *capsuleT) putArray(id int32, params []interface{}) (*ole.VARIANT, error) {
+	last := len(params) - 1
+	indices, err := toArgs(params[:last])
+	if err != nil {
+		return nil, err
 	}
-	e.Close()
-	return 0
+	defer clearArgs(indices)
+	args := append(indices, *params[last].(*ole.VARIANT))
+	return retryBusy(func() (*ole.VARIANT, error) {
+		return invokeNamed(c.Data, id, ole.DISPATCH_PROPERTYPUT, args, []int32{ole.DISPID_PROPERTYPUT})
+	})
 }
 
-func iterNext(L *lua.LState) int {
-	ud, ok := L.Get(1).(*lua.LUserData)
-	if !ok {
-		L.Push(lua.LNil)
-		return 1
+// isArrayParam reports whether the value made by lua2interface is
+// a SAFEARRAY like the tables and to_ole_array.
+func isArrayParam(param interface{}) bool {
+	v, ok := param.(*ole.VARIANT)
+	return ok && v != nil && v.VT&ole.VT_ARRAY != 0
+}
+
+// isObjectParam reports whether the value made by lua2interface is
+// an object: a capsuleT, or a VARIANT of an object such as OBJ.member
+// and to_ole_ref(OBJ).
+func isObjectParam(param interface{}) bool {
+	switch p := param.(type) {
+	case *ole.IDispatch:
+		return p != nil
+	case *ole.VARIANT:
+		return p != nil && (p.VT == ole.VT_DISPATCH || p.VT == ole.VT_UNKNOWN)
+	}
+	return false
+}
+
+type enumeratorT struct {
+	newEnum *ole.VARIANT
+	enum    *ole.IEnumVARIANT
+	index   int
+	// keep means that the enumerator is not closed at the end
+	// so that it can be reset. (made by _enum instead of _iter)
+	keep bool
+	// buffer has the items fetched by Next at once, and
+	// buffer[pos:count] are not returned yet.
+	buffer []ole.VARIANT
+	pos    int
+	count  int
+}
+
+const (
+	dispEMemberNotFound   = 0x80020003
+	dispEBadParamCount    = 0x8002000E
+	dispEParamNotOptional = 0x8002000F
+	dispEUnknownName      = 0x80020006
+	dispETypeMismatch     = 0x80020005
+)
+
+// notEnumerableError is the error for the objects which are not collections.
+const notEnumerableError = "object is not enumerable (no _NewEnum)"
+
+func newEnumerator(disp *ole.IDispatch) (*enumeratorT, error) {
+	newEnum, err := disp.GetProperty("_NewEnum")
+	if err != nil {
+		if code, ok := oleErrorCode(err); ok && (code == dispEUnknownName || code == dispEMemberNotFound) {
+			return nil, errors.New(notEnumerableError)
+		}
+		return nil, err
+	}
+	if (newEnum.VT != ole.VT_UNKNOWN && newEnum.VT != ole.VT_DISPATCH) || newEnum.Val == 0 {
+		newEnum.Clear()
+		return nil, errors.New(notEnumerableError)
+	}
+	enum, err := newEnum.ToIUnknown().IEnumVARIANT(ole.IID_IEnumVariant)
+	if err != nil {
+		newEnum.Clear()
+		return nil, fmt.Errorf("object is not enumerable (_NewEnum has no IEnumVARIANT): %s", err.Error())
+	}
+	return &enumeratorT{
+		enum:    enum,
+		newEnum: newEnum,
+	}, nil
+}
+
+func (e *enumeratorT) ToLValue(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = e
+	meta, ok := L.GetTypeMetatable(enumeratorTypeName).(*lua.LTable)
+	if !ok {
+		meta = L.NewTypeMetatable(enumeratorTypeName)
+		L.SetField(meta, "__gc", L.NewFunction(iterGc))
+		L.SetField(meta, "__call", L.NewFunction(iterNext))
+		L.SetField(meta, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"_next":  iterNext,
+			"_reset": enumReset,
+			"_skip":  enumSkip,
+			"_close": enumClose,
+		}))
+	}
+	L.SetMetatable(ud, meta)
+	return ud
+}
+
+func (e *enumeratorT) Close() error {
+	e.discard()
+	if e.enum != nil {
+		e.enum.Release()
+		e.enum = nil
+		e.newEnum.Clear()
+	}
+	return nil
+}
+
+func iterGc(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return 0
+	}
+	if ud.Value == nil {
+		return 0
+	}
+	e, ok := ud.Value.(*enumeratorT)
+	if !ok {
+		return 0
+	}
+	e.Close()
+	return 0
+}
+
+func iterNext(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
 	}
 	e, ok := ud.Value.(*enumeratorT)
 	if !ok {
 		L.Push(lua.LNil)
 		return 1
 	}
-	itemVariant, length, err := e.enum.Next(1)
-	if err != nil || length <= 0 {
-		e.Close()
-		ud.Value = nil
+	if e.enum == nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+	itemVariant, ok, err := e.next()
+	if err != nil || !ok {
+		if !e.keep {
+			e.Close()
+			ud.Value = nil
+		}
 		L.Push(lua.LNil)
 		if err != nil {
 			L.Push(lua.LString(err.Error()))
@@ -234,7 +1003,7 @@ func iterNext(L *lua.LState) int {
 			return 1
 		}
 	}
-	itemLValue, err := variantToLValue(L, &itemVariant)
+	itemLValue, err := resultToLValue(L, &itemVariant)
 	if err != nil {
 		L.Push(lua.LNil)
 		return 1
@@ -252,237 +1021,1658 @@ func iter(L *lua.LState) int {
 	if !ok {
 		return lerror(L, "get: 1st argument is not *capsuleT")
 	}
-	newEnum, err := p.Data.GetProperty("_NewEnum")
-	if err != nil {
-		return lerror(L, err.Error())
+	if p.Data == nil {
+		return lerror(L, "_iter: "+p.nullError())
 	}
-	enum, err := newEnum.ToIUnknown().IEnumVARIANT(ole.IID_IEnumVariant)
+	e, err := newEnumerator(p.Data)
 	if err != nil {
-		newEnum.Clear()
-		return lerror(L, err.Error())
+		return lerror(L, fmt.Sprintf("_iter: %s", err.Error()))
 	}
-	ud = L.NewUserData()
-	ud.Value = &enumeratorT{
-		enum:    enum,
-		newEnum: newEnum,
+	if lua.LVAsBool(L.Get(2)) {
+		// this:_iter(true) yields (index,value) as pairs.
+		L.Push(L.NewFunction(pairsNext))
+		L.Push(e.ToLValue(L))
+		L.Push(lua.LNil)
+		return 3
 	}
-	meta := L.NewTable()
-	L.SetField(meta, "__gc", L.NewFunction(iterGc))
-	L.SetMetatable(ud, meta)
-
 	L.Push(L.NewFunction(iterNext))
-	L.Push(ud)
+	L.Push(e.ToLValue(L))
 	L.Push(lua.LNil)
 	return 3
 }
 
-func get(L *lua.LState) int {
+// pairsNext is iterNext which returns the 1-based index with the item.
+func pairsNext(L *lua.LState) int {
 	ud, ok := L.Get(1).(*lua.LUserData)
 	if !ok {
-		return lerror(L, "get: 1st argument is not a userdata.")
+		L.Push(lua.LNil)
+		return 1
 	}
-	p, ok := ud.Value.(*capsuleT)
+	e, ok := ud.Value.(*enumeratorT)
 	if !ok {
-		return lerror(L, "get: 1st argument is not *capsuleT")
+		L.Push(lua.LNil)
+		return 1
+	}
+	if e.enum == nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+	itemVariant, ok, err := e.next()
+	if err != nil || !ok {
+		e.Close()
+		ud.Value = nil
+		L.Push(lua.LNil)
+		return 1
+	}
+	itemLValue, err := resultToLValue(L, &itemVariant)
+	if err != nil {
+		L.Push(lua.LNil)
+		return 1
 	}
+	e.index++
+	L.Push(lua.LNumber(e.index))
+	L.Push(itemLValue)
+	return 2
+}
 
-	name, ok := L.Get(2).(lua.LString)
-	if !ok {
-		return lerror(L, "get: 2nd argument is not string")
+// hasMembers reports whether the object has all the members.
+func hasMembers(disp *ole.IDispatch, names ...string) bool {
+	for _, name := range names {
+		if _, err := disp.GetSingleIDOfName(name); err != nil {
+			return false
+		}
 	}
+	return true
+}
 
-	key, err := lua2interfaceS(L, 3, L.GetTop())
+// itemsIterator returns the function which yields (key,value) of the
+// Scripting.Dictionary-like object from Keys() and Items(). Without Items(),
+// it reads the values by the property Item(key) on each step.
+func itemsIterator(L *lua.LState, p *capsuleT) (*lua.LFunction, error) {
+	keys, err := callToTable(L, p.Data, "Keys")
 	if err != nil {
-		return lerror(L, fmt.Sprintf("get: %s", err.Error()))
+		return nil, fmt.Errorf("Keys: %s", err.Error())
 	}
-	result, err := p.Data.GetProperty(string(name), key...)
-	if err != nil {
-		return lerror(L, fmt.Sprintf("oleutil.GetProperty: %s", err.Error()))
+	var items *lua.LTable
+	if hasMembers(p.Data, "Items") {
+		items, err = callToTable(L, p.Data, "Items")
+		if err != nil {
+			return nil, fmt.Errorf("Items: %s", err.Error())
+		}
 	}
-	val, err := variantToLValue(L, result)
-	if err == nil {
+	i := 0
+	return L.NewFunction(func(L *lua.LState) int {
+		i++
+		if i > keys.Len() {
+			L.Push(lua.LNil)
+			return 1
+		}
+		key := keys.RawGetInt(i)
+		if items != nil {
+			L.Push(key)
+			L.Push(items.RawGetInt(i))
+			return 2
+		}
+		param, err := lvalue2interface(L, key)
+		if err != nil {
+			L.RaiseError("_items: %s", err.Error())
+		}
+		params := []interface{}{param}
+		defer freeParams(params)
+		if p.Data == nil {
+			L.RaiseError("_items: %s", p.nullError())
+		}
+		result, err := p.GetPropertyByDispID("Item", params...)
+		if err != nil {
+			L.RaiseError("_items: Item(%s): %s", key.String(), err.Error())
+		}
+		val, err := resultToLValue(L, result)
+		if err != nil {
+			L.RaiseError("_items: Item(%s): %s", key.String(), err.Error())
+		}
+		L.Push(key)
 		L.Push(val)
-		return 1
-	} else {
-		L.Push(lua.LNil)
-		L.Push(lua.LString(err.Error()))
-		fmt.Fprintln(os.Stderr, err)
 		return 2
+	}), nil
+}
+
+// this:_items() returns the iterator of (key,value) for the objects with
+// Keys() (and Items() or Item(key)) like Scripting.Dictionary:
+// `for key,value in OBJ:_items() do ... end`
+func items(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_items: not a userdata")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_items: not a capsuleT")
 	}
+	if p.Data == nil {
+		return lerror(L, "_items: "+p.nullError())
+	}
+	if !hasMembers(p.Data, "Keys") {
+		return lerror(L, "_items: the object has no Keys() like Scripting.Dictionary")
+	}
+	next, err := itemsIterator(L, p)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_items: %s", err.Error()))
+	}
+	L.Push(next)
+	L.Push(lua.LNil)
+	L.Push(lua.LNil)
+	return 3
 }
 
-func indexSub(L *lua.LState, thisIndex int, nameIndex int) int {
-	name, ok := L.Get(nameIndex).(lua.LString)
+// pairs is the metamethod __pairs which yields (key,value) from Keys() and
+// Items() of Scripting.Dictionary-like objects, and otherwise (index,value)
+// from _NewEnum of collections. Since pairs of GopherLua ignores __pairs,
+// use Pairs instead of it.
+func pairs(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
 	if !ok {
-		return lerror(L, "indexSub: not a string")
+		L.RaiseError("pairs: not a userdata")
 	}
-	switch string(name) {
-	case "_call":
-		L.Push(L.NewFunction(call1))
-		L.Push(lua.LNil)
-		return 2
-	case "_set":
-		L.Push(L.NewFunction(set))
-		L.Push(lua.LNil)
-		return 2
-	case "_get":
-		L.Push(L.NewFunction(get))
-		L.Push(lua.LNil)
-		return 2
-	case "_iter":
-		L.Push(L.NewFunction(iter))
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		L.RaiseError("pairs: not a capsuleT")
+	}
+	if p.Data == nil {
+		L.RaiseError("pairs: %s", p.nullError())
+	}
+	if hasMembers(p.Data, "Keys", "Items") {
+		next, err := itemsIterator(L, p)
+		if err != nil {
+			L.RaiseError("pairs: %s", err.Error())
+		}
+		L.Push(next)
 		L.Push(lua.LNil)
-		return 2
-	case "_release":
-		L.Push(L.NewFunction(gc))
 		L.Push(lua.LNil)
-		return 2
-	default:
-		m := &methodT{Name: string(name)}
-		if ud, ok := L.Get(thisIndex).(*lua.LUserData); ok {
-			if p, ok := ud.Value.(*capsuleT); ok {
-				m.Data = p.Data
-			}
-		}
-		ud := L.NewUserData()
-		ud.Value = m
+		return 3
+	}
+	e, err := newEnumerator(p.Data)
+	if err != nil {
+		L.RaiseError("pairs: %s", err.Error())
+	}
+	L.Push(L.NewFunction(pairsNext))
+	L.Push(e.ToLValue(L))
+	L.Push(lua.LNil)
+	return 3
+}
 
-		meta := L.NewTable()
-		L.SetField(meta, "__newindex", L.NewFunction(set))
-		L.SetField(meta, "__call", L.NewFunction(call2))
-		L.SetField(meta, "__index", L.NewFunction(get2))
-		L.SetMetatable(ud, meta)
-		L.Push(ud)
+func callToTable(L *lua.LState, disp *ole.IDispatch, name string) (*lua.LTable, error) {
+	result, err := disp.CallMethod(name)
+	if err != nil {
+		return nil, err
+	}
+	val, err := resultToLValue(L, result)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := val.(*lua.LTable)
+	if !ok {
+		releaseLValue(val)
+		return nil, errors.New("not an array")
+	}
+	return t, nil
+}
 
-		return 1
+// Pairs is pairs which supports the metamethod __pairs.
+// Set it to the global "pairs" to write `for k,v in pairs(OBJ)`.
+func Pairs(L *lua.LState) int {
+	if fn := L.GetMetaField(L.Get(1), "__pairs"); fn != lua.LNil {
+		L.Push(fn)
+		L.Push(L.Get(1))
+		L.Call(1, 3)
+		return 3
 	}
+	tb := L.CheckTable(1)
+	L.Push(L.NewFunction(func(L *lua.LState) int {
+		key, value := L.CheckTable(1).Next(L.Get(2))
+		if key == lua.LNil {
+			L.Push(lua.LNil)
+			return 1
+		}
+		L.Push(key)
+		L.Push(value)
+		return 2
+	}))
+	L.Push(tb)
+	L.Push(lua.LNil)
+	return 3
 }
 
-func index(L *lua.LState) int {
-	return indexSub(L, 1, 2)
+func get(L *lua.LState) int {
+	result, n := getVariant(L, "get")
+	if result == nil {
+		return n
+	}
+	val, err := resultToLValue(L, result)
+	if err == nil {
+		L.Push(val)
+		return 1
+	} else {
+		return lerror(L, err.Error())
+	}
 }
 
-// THIS.member.member
-func get2(L *lua.LState) int {
+// getVariant reads the property for this:_get("NAME",key...) and the like.
+// On failure, it returns nil and the count of the values pushed for the error.
+func getVariant(L *lua.LState, fname string) (*ole.VARIANT, int) {
 	ud, ok := L.Get(1).(*lua.LUserData)
 	if !ok {
-		return lerror(L, "get2: not a userdata")
+		return nil, lerror(L, fname+": 1st argument is not a userdata.")
 	}
-	m, ok := ud.Value.(*methodT)
+	p, ok := ud.Value.(*capsuleT)
 	if !ok {
-		return lerror(L, "get: not a methodT")
+		return nil, lerror(L, fname+": 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return nil, lerror(L, fname+": "+p.nullError())
+	}
+
+	name, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return nil, lerror(L, fname+": 2nd argument is not string")
 	}
-	result, err := m.Data.GetProperty(m.Name)
+
+	key, err := lua2interfaceS(L, 3, L.GetTop())
 	if err != nil {
-		return lerror(L, fmt.Sprintf("oleutil.GetProperty: %s", err.Error()))
+		return nil, lerror(L, fmt.Sprintf("%s: %s", fname, err.Error()))
 	}
-	val, err := variantToLValue(L, result)
-	if err == nil {
-		L.Push(val)
-		return indexSub(L, 3, 2)
-	} else {
-		L.Push(lua.LNil)
-		L.Push(lua.LString(err.Error()))
-		fmt.Fprintln(os.Stderr, err)
-		return 2
+	defer freeParams(key)
+	result, err := p.GetPropertyByDispID(string(name), key...)
+	if err != nil {
+		return nil, comError(L, err, fmt.Sprintf("oleutil.GetProperty: %s", err.Error()))
 	}
+	return result, 0
 }
 
-// CreateObject creates *lua.LState-Object to access COM
-func CreateObject(L *lua.LState) int {
-	if initializedRequired {
-		ole.CoInitialize(0)
-		initializedRequired = false
+// this:_getor("NAME",key...,default) returns the value of the property,
+// or default when it can not be read (for example, the member is not found
+// on the older version of the server) without the error.
+func getOr(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_getor: 1st argument is not a userdata.")
 	}
-	name, ok := L.Get(1).(lua.LString)
+	p, ok := ud.Value.(*capsuleT)
 	if !ok {
-		return lerror(L, "CreateObject: parameter not a string")
+		return lerror(L, "_getor: 1st argument is not *capsuleT")
 	}
-	unknown, err := oleutil.CreateObject(string(name))
+	if p.Data == nil {
+		return lerror(L, "_getor: "+p.nullError())
+	}
+	name, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "_getor: 2nd argument is not string")
+	}
+	if L.GetTop() < 3 {
+		return lerror(L, "_getor: no default value")
+	}
+	def := L.Get(L.GetTop())
+	key, err := lua2interfaceS(L, 3, L.GetTop()-1)
 	if err != nil {
-		return lerror(L, fmt.Sprintf("oleutil.CreateObject: %s", err.Error()))
+		return lerror(L, fmt.Sprintf("_getor: %s", err.Error()))
 	}
-	defer unknown.Release()
-	obj, err := unknown.QueryInterface(ole.IID_IDispatch)
+	defer freeParams(key)
+	result, err := p.GetPropertyByDispID(string(name), key...)
 	if err != nil {
-		return lerror(L, fmt.Sprintf("unknown.QueryInterfce: %s", err.Error()))
+		L.Push(def)
+		return 1
 	}
-	L.Push(capsuleT{obj}.ToLValue(L))
+	val, err := resultToLValue(L, result)
+	if err != nil {
+		L.Push(def)
+		return 1
+	}
+	L.Push(val)
 	return 1
 }
 
-// ToOleInteger converts LNumber to integer which can be used by OLE parameter only.
-func ToOleInteger(L *lua.LState) int {
-	var value int
-	if v, ok := L.Get(-1).(lua.LNumber); ok {
-		value = int(v)
+// typedGetter returns the function of this:_get_TYPE("NAME",key...)
+// which returns the value of the property converted by convert,
+// or nil and the error when the VARIANT is not of the type.
+func typedGetter(fname, typeName string, convert func(v *ole.VARIANT) (lua.LValue, bool)) lua.LGFunction {
+	return func(L *lua.LState) int {
+		result, n := getVariant(L, fname)
+		if result == nil {
+			return n
+		}
+		defer result.Clear()
+		v := result
+		if v.VT == ole.VT_VARIANT|ole.VT_BYREF {
+			if v = *(**ole.VARIANT)(unsafe.Pointer(&v.Val)); v == nil {
+				v = &ole.VARIANT{VT: ole.VT_EMPTY}
+			}
+		}
+		val, ok := convert(v)
+		if !ok {
+			return lerror(L, fmt.Sprintf("%s: %s: %v is not %s",
+				fname, L.Get(2).String(), v.VT, typeName))
+		}
+		L.Push(val)
+		return 1
 	}
-	ud := L.NewUserData()
-	ud.Value = value
-	L.Push(ud)
-	return 1
 }
 
-func lerror(L *lua.LState, s string) int {
-	L.Push(lua.LNil)
-	L.Push(lua.LString(s))
-	fmt.Fprintln(os.Stderr, s)
-	return 2
+func isIntegerVT(vt ole.VT) bool {
+	switch vt {
+	case ole.VT_I1, ole.VT_I2, ole.VT_I4, ole.VT_I8, ole.VT_INT, ole.VT_INT_PTR,
+		ole.VT_UI1, ole.VT_UI2, ole.VT_UI4, ole.VT_UI8, ole.VT_UINT, ole.VT_UINT_PTR:
+		return true
+	}
+	return false
 }
 
-func variantToLValue(L *lua.LState, v *ole.VARIANT) (lua.LValue, error) {
+func integerToLValue(v *ole.VARIANT) lua.LValue {
+	switch v.VT {
+	case ole.VT_UI1, ole.VT_UI2, ole.VT_UI4, ole.VT_UI8, ole.VT_UINT, ole.VT_UINT_PTR:
+		return lua.LNumber(variantToUint64(v))
+	}
+	return lua.LNumber(variantToInt64(v))
+}
+
+// this:_get_int("NAME",key...) accepts only the integer types.
+var getInt = typedGetter("_get_int", "an integer", func(v *ole.VARIANT) (lua.LValue, bool) {
+	if !isIntegerVT(v.VT) {
+		return lua.LNil, false
+	}
+	return integerToLValue(v), true
+})
+
+// this:_get_number("NAME",key...) accepts the integers, the floats,
+// VT_CY and VT_DECIMAL, and always returns a number
+// regardless of CurrencyAsString and DecimalAsString.
+var getNumber = typedGetter("_get_number", "a number", func(v *ole.VARIANT) (lua.LValue, bool) {
+	switch {
+	case isIntegerVT(v.VT):
+		return integerToLValue(v), true
+	case v.VT == ole.VT_R4:
+		return lua.LNumber(v.Value().(float32)), true
+	case v.VT == ole.VT_R8:
+		return lua.LNumber(v.Value().(float64)), true
+	case v.VT == ole.VT_CY:
+		return lua.LNumber(float64(v.Val) / 10000), true
+	case v.VT == ole.VT_DECIMAL:
+		f, err := strconv.ParseFloat(formatScaled(decimalToBigInt(v)), 64)
+		return lua.LNumber(f), err == nil
+	}
+	return lua.LNil, false
+})
+
+// this:_get_string("NAME",key...) accepts only VT_BSTR.
+var getString = typedGetter("_get_string", "a string", func(v *ole.VARIANT) (lua.LValue, bool) {
+	if v.VT != ole.VT_BSTR {
+		return lua.LNil, false
+	}
+	return lua.LString(bstrToString(*(**uint16)(unsafe.Pointer(&v.Val)))), true
+})
+
+// this:_get_bool("NAME",key...) accepts only VT_BOOL.
+var getBool = typedGetter("_get_bool", "a boolean", func(v *ole.VARIANT) (lua.LValue, bool) {
+	if v.VT != ole.VT_BOOL {
+		return lua.LNil, false
+	}
+	return lua.LBool(int16(v.Val) != 0), true
+})
+
+// this:_path("A.B.C") returns the value of this.A.B.C read by GetProperty
+// of each name. The error tells the link which failed like "_path: A.B: ...".
+// The intermediate objects are released.
+func getPath(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_path: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_path: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_path: "+p.nullError())
+	}
+	spec, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "_path: 2nd argument is not string")
+	}
+	names := strings.Split(string(spec), ".")
+	var intermediate *capsuleT
+	defer func() {
+		if intermediate != nil {
+			intermediate.release()
+		}
+	}()
+	current := p
+	for i, name := range names {
+		link := strings.Join(names[:i+1], ".")
+		if name == "" {
+			return lerror(L, fmt.Sprintf("_path: %q: empty name at %s", string(spec), link))
+		}
+		result, err := current.GetPropertyByDispID(name)
+		if err != nil {
+			return comError(L, err, fmt.Sprintf("_path: %s: %s", link, err.Error()))
+		}
+		if i == len(names)-1 {
+			val, err := resultToLValue(L, result)
+			if err != nil {
+				return lerror(L, fmt.Sprintf("_path: %s: %s", link, err.Error()))
+			}
+			L.Push(val)
+			return 1
+		}
+		if result.VT != ole.VT_DISPATCH || result.Val == 0 {
+			result.Clear()
+			return lerror(L, fmt.Sprintf("_path: %s: %s", link, nullObjectError))
+		}
+		next := &capsuleT{Data: result.ToIDispatch()}
+		if intermediate != nil {
+			intermediate.release()
+		}
+		intermediate, current = next, next
+	}
+	return 0
+}
+
+// this:_getnull("NAME",key...) returns the value of the property and
+// whether it is VT_NULL (no data, e.g., NULL of the database), which
+// _get returns as nil like VT_EMPTY.
+func getNull(L *lua.LState) int {
+	result, n := getVariant(L, "_getnull")
+	if result == nil {
+		return n
+	}
+	isNull := result.VT == ole.VT_NULL
+	val, err := resultToLValue(L, result)
+	if err != nil {
+		return lerror(L, err.Error())
+	}
+	L.Push(val)
+	L.Push(lua.LBool(isNull))
+	return 2
+}
+
+// this:_default(params...) and this(params...) call the default member
+// (DISPID_VALUE) as VBScript's `OBJ(params...)`. It is invoked as both a method and a property
+// getter, so it works whichever the server defines the member as.
+func callDefault(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "_default: 1st argument is not a userdata.")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "_default: 1st argument is not *capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "_default: "+p.nullError())
+	}
+	params, err := lua2interfaceS(L, 2, L.GetTop())
+	if err != nil {
+		return lerror(L, fmt.Sprintf("_default: %s", err.Error()))
+	}
+	defer freeParams(params)
+	result, err := p.invoke(ole.DISPID_VALUE, ole.DISPATCH_METHOD|ole.DISPATCH_PROPERTYGET, params)
+	if err != nil {
+		return comError(L, err, fmt.Sprintf("_default: %s", err.Error()))
+	}
+	val, err := resultToLValue(L, result)
+	if err != nil {
+		return lerror(L, err.Error())
+	}
+	L.Push(val)
+	return 1 + pushRefParams(L, 2, params)
+}
+
+// indexDefault returns the default member (DISPID_VALUE, otherwise "Item")
+// with the key for `OBJ[key]` as VBScript's `OBJ(key)`.
+func indexDefault(L *lua.LState, thisIndex int, key lua.LNumber) int {
+	ud, ok := L.Get(thisIndex).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "indexDefault: not a userdata")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "indexDefault: not a capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "indexDefault: "+p.nullError())
+	}
+	var param interface{} = float64(key)
+	if i := int(key); lua.LNumber(i) == key {
+		param = i
+	}
+	result, err := p.invoke(ole.DISPID_VALUE, ole.DISPATCH_METHOD|ole.DISPATCH_PROPERTYGET, []interface{}{param})
+	if err != nil {
+		var err2 error
+		result, err2 = p.GetPropertyByDispID("Item", param)
+		if err2 != nil {
+			return comError(L, err, fmt.Sprintf("indexDefault: %s", err.Error()))
+		}
+	}
+	val, err := resultToLValue(L, result)
+	if err != nil {
+		return lerror(L, err.Error())
+	}
+	L.Push(val)
+	return 1
+}
+
+func indexSub(L *lua.LState, thisIndex int, nameIndex int) int {
+	if key, ok := L.Get(nameIndex).(lua.LNumber); ok {
+		return indexDefault(L, thisIndex, key)
+	}
+	name, ok := L.Get(nameIndex).(lua.LString)
+	if !ok {
+		return lerror(L, "indexSub: not a string")
+	}
+	switch string(name) {
+	case "_call":
+		L.Push(L.NewFunction(call1))
+		L.Push(lua.LNil)
+		return 2
+	case "_apply":
+		L.Push(L.NewFunction(apply))
+		L.Push(lua.LNil)
+		return 2
+	case "_set":
+		if SetReturnsPrevious {
+			L.Push(L.NewFunction(setPrevious))
+			L.Push(lua.LNil)
+			return 2
+		}
+		L.Push(L.NewFunction(set))
+		L.Push(lua.LNil)
+		return 2
+	case "_get":
+		L.Push(L.NewFunction(get))
+		L.Push(lua.LNil)
+		return 2
+	case "_getor":
+		L.Push(L.NewFunction(getOr))
+		L.Push(lua.LNil)
+		return 2
+	case "_getnull":
+		L.Push(L.NewFunction(getNull))
+		L.Push(lua.LNil)
+		return 2
+	case "_path":
+		L.Push(L.NewFunction(getPath))
+		L.Push(lua.LNil)
+		return 2
+	case "_get_int":
+		L.Push(L.NewFunction(getInt))
+		L.Push(lua.LNil)
+		return 2
+	case "_get_number":
+		L.Push(L.NewFunction(getNumber))
+		L.Push(lua.LNil)
+		return 2
+	case "_get_string":
+		L.Push(L.NewFunction(getString))
+		L.Push(lua.LNil)
+		return 2
+	case "_get_bool":
+		L.Push(L.NewFunction(getBool))
+		L.Push(lua.LNil)
+		return 2
+	case "_connect":
+		L.Push(L.NewFunction(connectEvent))
+		L.Push(lua.LNil)
+		return 2
+	case "_default":
+		L.Push(L.NewFunction(callDefault))
+		L.Push(lua.LNil)
+		return 2
+	case "_invoke":
+		L.Push(L.NewFunction(invokeByDispID))
+		L.Push(lua.LNil)
+		return 2
+	case "_callnamed":
+		L.Push(L.NewFunction(callNamed))
+		L.Push(lua.LNil)
+		return 2
+	case "_iter":
+		L.Push(L.NewFunction(iter))
+		L.Push(lua.LNil)
+		return 2
+	case "_items":
+		L.Push(L.NewFunction(items))
+		L.Push(lua.LNil)
+		return 2
+	case "_toarray":
+		L.Push(L.NewFunction(toArray))
+		L.Push(lua.LNil)
+		return 2
+	case "_enum":
+		L.Push(L.NewFunction(openEnum))
+		L.Push(lua.LNil)
+		return 2
+	case "_release":
+		L.Push(L.NewFunction(gc))
+		L.Push(lua.LNil)
+		return 2
+	case "_clone":
+		L.Push(L.NewFunction(clone))
+		L.Push(lua.LNil)
+		return 2
+	case "_addref":
+		L.Push(L.NewFunction(addRef))
+		L.Push(lua.LNil)
+		return 2
+	case "_refcount":
+		L.Push(L.NewFunction(refCount))
+		L.Push(lua.LNil)
+		return 2
+	case "_dispid":
+		L.Push(L.NewFunction(dispid))
+		L.Push(lua.LNil)
+		return 2
+	case "_typename":
+		L.Push(L.NewFunction(typename))
+		L.Push(lua.LNil)
+		return 2
+	case "_queryinterface":
+		L.Push(L.NewFunction(queryInterface))
+		L.Push(lua.LNil)
+		return 2
+	case "_supports":
+		L.Push(L.NewFunction(supports))
+		L.Push(lua.LNil)
+		return 2
+	case "_is_a":
+		L.Push(L.NewFunction(isA))
+		L.Push(lua.LNil)
+		return 2
+	case "_methods":
+		L.Push(L.NewFunction(methods))
+		L.Push(lua.LNil)
+		return 2
+	case "_member":
+		L.Push(L.NewFunction(member))
+		L.Push(lua.LNil)
+		return 2
+	default:
+		return pushMember(L, thisIndex, string(name))
+	}
+}
+
+// pushMember pushes the methodT of the member of the object at thisIndex.
+func pushMember(L *lua.LState, thisIndex int, name string) int {
+	m := &methodT{Name: name}
+	if ud, ok := L.Get(thisIndex).(*lua.LUserData); ok {
+		if p, ok := ud.Value.(*capsuleT); ok {
+			m.Data = p.Data
+			m.owner = p
+		}
+	}
+	ud := L.NewUserData()
+	ud.Value = m
+	L.SetMetatable(ud, methodMeta(L))
+	L.Push(ud)
+
+	return 1
+}
+
+// this:_member("NAME") returns the member as this.NAME does, even if NAME
+// is a reserved name like "_get", so that this:_member("_get")(this,...)
+// calls the COM member named "_get".
+func member(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "member: not a userdata")
+	}
+	p, ok := ud.Value.(*capsuleT)
+	if !ok {
+		return lerror(L, "member: not a capsuleT")
+	}
+	if p.Data == nil {
+		return lerror(L, "member: "+p.nullError())
+	}
+	name, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "member: member name not a string")
+	}
+	return pushMember(L, 1, string(name))
+}
+
+func index(L *lua.LState) int {
+	return indexSub(L, 1, 2)
+}
+
+// needsArguments tells whether the error is from the property read
+// without the arguments which it requires.
+func needsArguments(err error) bool {
+	code, ok := oleErrorCode(err)
+	return ok && (code == dispEBadParamCount || code == dispEParamNotOptional)
+}
+
+// THIS.member.member
+func get2(L *lua.LState) int {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return lerror(L, "get2: not a userdata")
+	}
+	m, ok := ud.Value.(*methodT)
+	if !ok {
+		return lerror(L, "get: not a methodT")
+	}
+	if m.indexed != "" {
+		m.release()
+		return lerror(L, fmt.Sprintf("get2: %s: %s needs the arguments", m.Name, m.indexed))
+	}
+	owner := m.owner
+	if owner == nil {
+		owner = &capsuleT{Data: m.Data}
+	}
+	if owner.Data == nil {
+		return lerror(L, fmt.Sprintf("get2: %s: %s", m.Name, owner.nullError()))
+	}
+	result, err := owner.GetPropertyByDispID(m.Name)
+	if err != nil {
+		if name, ok := L.Get(2).(lua.LString); ok && needsArguments(err) {
+			// the owner moves to the deferred methodT with the temporary flag.
+			deferred := &methodT{
+				Name:      string(name),
+				Data:      m.Data,
+				owner:     m.owner,
+				temporary: m.temporary,
+				indexed:   m.Name,
+			}
+			m.temporary = false
+			ud := L.NewUserData()
+			ud.Value = deferred
+			L.SetMetatable(ud, methodMeta(L))
+			L.Push(ud)
+			return 1
+		}
+		m.release()
+		return comError(L, err, fmt.Sprintf("oleutil.GetProperty: %s", err.Error()))
+	}
+	m.release()
+	val, err := resultToLValue(L, result)
+	if err == nil {
+		L.Push(val)
+		n := indexSub(L, 3, 2)
+		// the intermediate object is kept only by the next methodT.
+		// Otherwise (OBJ.member[N] and so on) it is not needed anymore.
+		if next, ok := L.Get(-1).(*lua.LUserData); ok && n == 1 {
+			if nextMethod, ok := next.Value.(*methodT); ok && nextMethod.owner != nil {
+				nextMethod.temporary = true
+				return n
+			}
+		}
+		if ud, ok := val.(*lua.LUserData); ok {
+			if p, ok := ud.Value.(*capsuleT); ok {
+				p.release()
+			}
+		}
+		return n
+	} else {
+		return lerror(L, err.Error())
+	}
+}
+
+// CreateObject creates *lua.LState-Object to access COM.
+// It returns the object and nil, or nil and the error message.
+// The optional 2nd parameter is the server name as VBScript's
+// CreateObject(servername.typename, location), which creates the object
+// by DCOM like CreateObjectRemote. nil or "" means the local machine.
+func CreateObject(L *lua.LState) int {
+	if initializedRequired {
+		initialize(ole.COINIT_APARTMENTTHREADED)
+	}
+	if L.GetTop() > 2 {
+		return lerror(L, fmt.Sprintf("CreateObject: too many parameters (%d for 1 or 2)", L.GetTop()))
+	}
+	name, ok := L.Get(1).(lua.LString)
+	if !ok {
+		return lerror(L, "CreateObject: parameter not a string")
+	}
+	switch location := L.Get(2).(type) {
+	case lua.LString:
+		if location != "" {
+			return createObjectRemote(L, "CreateObject", string(name), string(location))
+		}
+	case *lua.LNilType:
+	default:
+		return lerror(L, fmt.Sprintf("CreateObject: location not a string but %s", location.Type().String()))
+	}
+	unknown, err := oleutil.CreateObject(string(name))
+	if err != nil {
+		return lerror(L, fmt.Sprintf("oleutil.CreateObject: %s", err.Error()))
+	}
+	// IDispatch has its own reference, so IUnknown is released on both paths.
+	defer unknown.Release()
+	obj, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("CreateObject: %s: the object does not support IDispatch: %s", name, err.Error()))
+	}
+	L.Push(capsuleT{Data: obj}.ToLValue(L))
+	L.Push(lua.LNil)
+	return 2
+}
+
+// CreateObjectFromCLSID creates the COM object from the CLSID string
+// like "{EE09B103-97E0-11CF-978F-00A02463E06F}" without the ProgID.
+// It returns the object and nil, or nil and the error message.
+func CreateObjectFromCLSID(L *lua.LState) int {
+	if initializedRequired {
+		initialize(ole.COINIT_APARTMENTTHREADED)
+	}
+	name, ok := L.Get(1).(lua.LString)
+	if !ok {
+		return lerror(L, "CreateObjectFromCLSID: parameter not a string")
+	}
+	clsid, err := ole.CLSIDFromString(string(name))
+	if err != nil {
+		return lerror(L, fmt.Sprintf("CreateObjectFromCLSID: %s: invalid CLSID: %s", name, err.Error()))
+	}
+	unknown, err := ole.CreateInstance(clsid, ole.IID_IUnknown)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("CreateObjectFromCLSID: %s: %s", name, err.Error()))
+	}
+	defer unknown.Release()
+	obj, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("unknown.QueryInterfce: %s", err.Error()))
+	}
+	L.Push(capsuleT{Data: obj}.ToLValue(L))
+	L.Push(lua.LNil)
+	return 2
+}
+
+const (
+	eAccessDenied         = 0x80070005
+	rpcSServerUnavailable = 0x800706BA
+	rpcSCallFailedDne     = 0x800706BF
+	coEServerExecFailure  = 0x80080005
+	regDBEClassNotReg     = 0x80040154
+)
+
+// remoteErrorMessage explains the errors which happen often with DCOM.
+func remoteErrorMessage(err error, server string) string {
+	oleErr, ok := err.(*ole.OleError)
+	if !ok {
+		return err.Error()
+	}
+	switch uint32(oleErr.Code()) {
+	case eAccessDenied:
+		return fmt.Sprintf("access denied by %s (check DCOM launch and access permissions)", server)
+	case rpcSServerUnavailable, rpcSCallFailedDne:
+		return fmt.Sprintf("RPC server %s is unavailable (check the name, the network and the firewall)", server)
+	case coEServerExecFailure:
+		return fmt.Sprintf("the server application failed to start on %s", server)
+	case regDBEClassNotReg:
+		return fmt.Sprintf("the class is not registered on %s", server)
+	}
+	return err.Error()
+}
+
+// CreateObjectRemote creates the object on the remote machine by DCOM
+// as `CreateObjectRemote("Excel.Application","SERVERNAME")` like
+// the second parameter of VBScript's CreateObject.
+// The ProgID has to be registered on the local machine too,
+// otherwise give the CLSID as "{...}".
+func CreateObjectRemote(L *lua.LState) int {
+	if initializedRequired {
+		initialize(ole.COINIT_APARTMENTTHREADED)
+	}
+	name, ok := L.Get(1).(lua.LString)
+	if !ok {
+		return lerror(L, "CreateObjectRemote: 1st parameter not a string")
+	}
+	server, ok := L.Get(2).(lua.LString)
+	if !ok {
+		return lerror(L, "CreateObjectRemote: 2nd parameter not a string")
+	}
+	return createObjectRemote(L, "CreateObjectRemote", string(name), string(server))
+}
+
+// createObjectRemote is CreateObjectRemote and CreateObject(name,server)
+// whose error messages start with fname.
+func createObjectRemote(L *lua.LState, fname, name, server string) int {
+	var clsid *ole.GUID
+	var err error
+	if strings.HasPrefix(name, "{") {
+		clsid, err = ole.CLSIDFromString(name)
+	} else {
+		clsid, err = ole.CLSIDFromProgID(name)
+	}
+	if err != nil {
+		return lerror(L, fmt.Sprintf("%s: %s: %s", fname, name, err.Error()))
+	}
+	obj, err := createRemoteInstance(clsid, server)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("%s: %s: %s", fname, name, remoteErrorMessage(err, server)))
+	}
+	L.Push(capsuleT{Data: obj}.ToLValue(L))
+	L.Push(lua.LNil)
+	return 2
+}
+
+// GetObject returns the object like VBScript's GetObject([pathname] [,class]).
+//
+//	GetObject(nil,"Excel.Application") attaches to the running instance.
+//	GetObject("","Excel.Application") creates a new instance.
+//	GetObject("C:\\book.xlsx") binds the file moniker.
+//	GetObject("C:\\book.xlsx","Excel.Sheet") loads the file into the class.
+func GetObject(L *lua.LState) int {
+	if initializedRequired {
+		initialize(ole.COINIT_APARTMENTTHREADED)
+	}
+	pathname, hasPathname := L.Get(1).(lua.LString)
+	if !hasPathname && L.Get(1) != lua.LNil {
+		return lerror(L, "GetObject: 1st parameter not a string")
+	}
+	class, ok := L.Get(2).(lua.LString)
+	if !ok && L.Get(2) != lua.LNil {
+		return lerror(L, "GetObject: 2nd parameter not a string")
+	}
+	var unknown *ole.IUnknown
+	var err error
+	if class == "" {
+		if pathname == "" {
+			return lerror(L, "GetObject: neither pathname nor class is given")
+		}
+		unknown, err = bindToObject(string(pathname))
+		if err != nil {
+			return lerror(L, fmt.Sprintf("ole.GetObject: %s", err.Error()))
+		}
+	} else if !hasPathname {
+		unknown, err = oleutil.GetActiveObject(string(class))
+		if err != nil {
+			return lerror(L, fmt.Sprintf("oleutil.GetActiveObject: %s", err.Error()))
+		}
+	} else {
+		unknown, err = oleutil.CreateObject(string(class))
+		if err != nil {
+			return lerror(L, fmt.Sprintf("oleutil.CreateObject: %s", err.Error()))
+		}
+		if pathname != "" {
+			if err := loadFromFile(unknown, string(pathname)); err != nil {
+				unknown.Release()
+				return lerror(L, fmt.Sprintf("IPersistFile.Load: %s", err.Error()))
+			}
+		}
+	}
+	defer unknown.Release()
+	obj, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("unknown.QueryInterfce: %s", err.Error()))
+	}
+	L.Push(capsuleT{Data: obj}.ToLValue(L))
+	L.Push(lua.LNil)
+	return 2
+}
+
+const mkEUnavailable = 0x800401E3
+
+// GetActiveObject returns the running instance of the ProgID registered
+// in the running object table, like GetObject(nil,progid) without creating
+// it or binding the files. When none is running, it returns nil and
+// the error of MK_E_UNAVAILABLE (0x800401E3).
+func GetActiveObject(L *lua.LState) int {
+	if initializedRequired {
+		initialize(ole.COINIT_APARTMENTTHREADED)
+	}
+	name, ok := L.Get(1).(lua.LString)
+	if !ok {
+		return lerror(L, "GetActiveObject: parameter not a string")
+	}
+	unknown, err := oleutil.GetActiveObject(string(name))
+	if err != nil {
+		if code, ok := oleErrorCode(err); ok && code == mkEUnavailable {
+			return comError(L, err, fmt.Sprintf("GetActiveObject: %s: not running", name))
+		}
+		return comError(L, err, fmt.Sprintf("GetActiveObject: %s: %s", name, err.Error()))
+	}
+	defer unknown.Release()
+	obj, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("unknown.QueryInterfce: %s", err.Error()))
+	}
+	L.Push(capsuleT{Data: obj}.ToLValue(L))
+	L.Push(lua.LNil)
+	return 2
+}
+
+// coinitFlags are the names of the flags of CoInitializeEx for Initialize.
+var coinitFlags = map[string]uint32{
+	"sta":               ole.COINIT_APARTMENTTHREADED,
+	"mta":               ole.COINIT_MULTITHREADED,
+	"disable_ole1dde":   ole.COINIT_DISABLE_OLE1DDE,
+	"speed_over_memory": ole.COINIT_SPEED_OVER_MEMORY,
+}
+
+// parseCoinit reads the flags of Initialize: a bitmask of COINIT_*,
+// the names separated by spaces, commas or "|" like "sta|disable_ole1dde",
+// or a table of the names. Without "sta" nor "mta", it is STA.
+func parseCoinit(value lua.LValue) (uint32, error) {
+	switch v := value.(type) {
+	case lua.LNumber:
+		coinit := uint32(v)
+		const known = ole.COINIT_APARTMENTTHREADED | ole.COINIT_DISABLE_OLE1DDE | ole.COINIT_SPEED_OVER_MEMORY
+		if float64(coinit) != float64(v) || coinit&^known != 0 {
+			return 0, fmt.Errorf("%v: unknown flags", float64(v))
+		}
+		return coinit, nil
+	case lua.LString:
+		names := strings.FieldsFunc(strings.ToLower(string(v)), func(r rune) bool {
+			return r == ' ' || r == ',' || r == '|'
+		})
+		return coinitOfNames(names)
+	case *lua.LTable:
+		var names []string
+		var err error
+		v.ForEach(func(_, name lua.LValue) {
+			if s, ok := name.(lua.LString); ok {
+				names = append(names, strings.ToLower(string(s)))
+			} else {
+				err = fmt.Errorf("%s: not a flag name", name.String())
+			}
+		})
+		if err != nil {
+			return 0, err
+		}
+		return coinitOfNames(names)
+	case *lua.LNilType:
+		return ole.COINIT_APARTMENTTHREADED, nil
+	}
+	return 0, fmt.Errorf("%s: not flags", value.Type().String())
+}
+
+func coinitOfNames(names []string) (uint32, error) {
+	var coinit uint32 = ole.COINIT_APARTMENTTHREADED
+	hasModel := false
+	for _, name := range names {
+		flag, ok := coinitFlags[name]
+		if !ok {
+			return 0, fmt.Errorf("%s: unknown flag", name)
+		}
+		if name == "sta" || name == "mta" {
+			if hasModel && flag != coinit&ole.COINIT_APARTMENTTHREADED {
+				return 0, errors.New("both sta and mta are given")
+			}
+			hasModel = true
+			coinit = coinit&^ole.COINIT_APARTMENTTHREADED | flag
+		} else {
+			coinit |= flag
+		}
+	}
+	return coinit, nil
+}
+
+// Initialize initializes COM with the threading model "sta"(default) or "mta"
+// instead of the lazy initialization of CreateObject. The other flags
+// of CoInitializeEx are given with it like "sta|disable_ole1dde",
+// {"sta","disable_ole1dde"} or the bitmask of COINIT_*.
+// It returns an error if COM is already initialized with the other model.
+func Initialize(L *lua.LState) int {
+	coinit, err := parseCoinit(L.Get(1))
+	if err != nil {
+		return lerror(L, fmt.Sprintf("Initialize: %s", err.Error()))
+	}
+	if !initializedRequired {
+		if coinit&ole.COINIT_APARTMENTTHREADED != initializedModel&ole.COINIT_APARTMENTTHREADED {
+			return lerror(L, "Initialize: COM is already initialized with the other threading model")
+		}
+		L.Push(lua.LTrue)
+		return 1
+	}
+	if err := initialize(coinit); err != nil {
+		return lerror(L, fmt.Sprintf("ole.CoInitializeEx: %s", err.Error()))
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// comThreadID is the OS thread which initialized COM.
+var comThreadID uint32
+
+// initialize locks the goroutine to the current OS thread and initializes
+// COM on it, because objects of STA must be used on the thread which
+// created them. (Otherwise calls can fail with RPC_E_WRONG_THREAD)
+func initialize(coinit uint32) error {
+	runtime.LockOSThread()
+	if err := ole.CoInitializeEx(0, coinit); err != nil {
+		// S_FALSE means COM is already initialized on this thread.
+		if oleErr, ok := err.(*ole.OleError); !ok || oleErr.Code() != 1 {
+			runtime.UnlockOSThread()
+			return err
+		}
+	}
+	initializedRequired = false
+	initializedModel = coinit
+	comThreadID = currentThreadID()
+	return nil
+}
+
+// IsComThread reports whether the caller runs on the OS thread which
+// initialized COM. Embedders can use it to make sure that CreateObject
+// and method calls happen on the locked thread.
+func IsComThread() bool {
+	return !initializedRequired && comThreadID == currentThreadID()
+}
+
+// Uninitialize calls CoUninitialize to close COM initialized by CreateObject
+// and unlocks the OS thread locked by the initialization.
+// It must be called on the same OS thread which initialized COM.
+// Calling it twice does nothing, and later CreateObject initializes COM again.
+func Uninitialize(L *lua.LState) int {
+	if !initializedRequired {
+		ole.CoUninitialize()
+		initializedRequired = true
+		runtime.UnlockOSThread()
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// oleParam is the scalar value made by the ToOle* functions, which is sent
+// as the VARIANT of VT. Value is the Go value for go-ole: int for VT_I4,
+// int64 for VT_I8, uint8 for VT_UI1, uint32 for VT_UI4, string for VT_BSTR,
+// bool for VT_BOOL, float64 for VT_DATE, missingT for VT_ERROR and nil for
+// VT_NULL and VT_EMPTY. (The dates of time.Time, the references and
+// the arrays have their own types.)
+type oleParam struct {
+	VT    ole.VT
+	Value interface{}
+}
+
+// pushParam pushes the userdata of the oleParam.
+func pushParam(L *lua.LState, vt ole.VT, value interface{}) {
+	ud := L.NewUserData()
+	ud.Value = &oleParam{VT: vt, Value: value}
+	L.Push(ud)
+}
+
+// param returns the value for go-ole and toVariant like lua2interface.
+func (p *oleParam) param() interface{} {
+	switch p.VT {
+	case ole.VT_UI1:
+		// go-ole sends uint8 as VT_I1
+		v := ole.NewVariant(ole.VT_UI1, int64(p.Value.(uint8)))
+		return &v
+	case ole.VT_EMPTY:
+		v := ole.NewVariant(ole.VT_EMPTY, 0)
+		return &v
+	case ole.VT_DATE:
+		v := ole.NewVariant(ole.VT_DATE, int64(math.Float64bits(p.Value.(float64))))
+		return &v
+	}
+	return p.Value
+}
+
+// ToOleInteger converts LNumber to integer which can be used by OLE parameter only.
+// A numeric string like "10" is accepted as Lua's tonumber does.
+// It returns nil and the error for the others and the values beyond 32 bits.
+func ToOleInteger(L *lua.LState) int {
+	var value float64
+	switch v := L.Get(1).(type) {
+	case lua.LNumber:
+		value = float64(v)
+	case lua.LString:
+		s := strings.TrimSpace(string(v))
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			n, err := strconv.ParseInt(s, 0, 64)
+			if err != nil {
+				return lerror(L, fmt.Sprintf("ToOleInteger: %q: not a number", string(v)))
+			}
+			f = float64(n)
+		}
+		value = f
+	default:
+		return lerror(L, fmt.Sprintf("ToOleInteger: %s: not a number", L.Get(1).Type().String()))
+	}
+	if value < math.MinInt32 || value > math.MaxInt32 || math.IsNaN(value) {
+		return lerror(L, fmt.Sprintf("ToOleInteger: %v is out of range of 32-bit integer", value))
+	}
+	pushParam(L, ole.VT_I4, int(value))
+	return 1
+}
+
+func checkUnsigned(L *lua.LState, max float64) float64 {
+	n := float64(L.CheckNumber(1))
+	if n < 0 || n > max || n != math.Trunc(n) {
+		L.ArgError(1, fmt.Sprintf("%v is out of range 0..%v", n, max))
+	}
+	return n
+}
+
+// ToOleByte makes the unsigned 8-bit integer value (VT_UI1) for OLE parameter.
+// It raises an error when the number is not an integer within 0..255.
+func ToOleByte(L *lua.LState) int {
+	value := checkUnsigned(L, math.MaxUint8)
+	pushParam(L, ole.VT_UI1, uint8(value))
+	return 1
+}
+
+// ToOleUInt makes the unsigned 32-bit integer value (VT_UI4) for OLE parameter.
+// It raises an error when the number is not an integer within 0..4294967295.
+func ToOleUInt(L *lua.LState) int {
+	value := checkUnsigned(L, math.MaxUint32)
+	pushParam(L, ole.VT_UI4, uint32(value))
+	return 1
+}
+
+// missingT is the omitted parameter which is sent as VT_ERROR with
+// DISP_E_PARAMNOTFOUND by value. go-ole can not send it, so the calls
+// with it are invoked by invokeParams.
+type missingT struct{}
+
+const dispEParamNotFound = 0x80020004
+
+// ToOleNull makes VT_NULL for OLE parameter. It means "no valid data"
+// like SQL NULL, for example, to assign NULL to ADO fields.
+func ToOleNull(L *lua.LState) int {
+	pushParam(L, ole.VT_NULL, nil)
+	return 1
+}
+
+// ToOleEmpty makes VT_EMPTY for OLE parameter. It means "not initialized"
+// like VBScript's Empty, for example, for omitted optional parameters
+// of servers which do not require DISP_E_PARAMNOTFOUND.
+func ToOleEmpty(L *lua.LState) int {
+	pushParam(L, ole.VT_EMPTY, nil)
+	return 1
+}
+
+// ToOleMissing makes the placeholder of the omitted optional parameter.
+// The server uses its default value for it as VBScript's skipped
+// parameters like `OBJ.METHOD a,,c`.
+func ToOleMissing(L *lua.LState) int {
+	pushParam(L, ole.VT_ERROR, missingT{})
+	return 1
+}
+
+// ToOleInt64 makes the 64-bit integer value (VT_I8) for OLE parameter
+// from a number or a numeric string. Use a string for the values beyond
+// 2^53 which a Lua number can not hold exactly. The numbers which are not
+// integers within -2^63..2^63-1 are rejected.
+func ToOleInt64(L *lua.LState) int {
+	var value int64
+	switch v := L.Get(1).(type) {
+	case lua.LNumber:
+		n := float64(v)
+		if n != math.Trunc(n) || n < -(1<<63) || n >= 1<<63 {
+			return lerror(L, fmt.Sprintf("ToOleInt64: %v is not an integer within -2^63..2^63-1", n))
+		}
+		value = int64(n)
+	case lua.LString:
+		var err error
+		value, err = strconv.ParseInt(strings.TrimSpace(string(v)), 0, 64)
+		if err != nil {
+			return lerror(L, fmt.Sprintf("ToOleInt64: %s", err.Error()))
+		}
+	default:
+		return lerror(L, "ToOleInt64: parameter not a number or a string")
+	}
+	pushParam(L, ole.VT_I8, value)
+	return 1
+}
+
+// ToOleString makes the string value (VT_BSTR) for OLE parameter
+// from a string, a number or a boolean. It keeps "01234" a string
+// and sends 1234 as "1234".
+func ToOleString(L *lua.LState) int {
+	switch v := L.Get(1).(type) {
+	case lua.LString, lua.LNumber, lua.LBool:
+		pushParam(L, ole.VT_BSTR, v.String())
+	default:
+		return lerror(L, "ToOleString: parameter not a string, number or boolean")
+	}
+	return 1
+}
+
+// ToOleBool makes the boolean value (VT_BOOL) for OLE parameter from any value
+// for the methods which distinguish VT_BOOL from VT_I4. nil, false, 0 and ""
+// are false, and the others are true.
+func ToOleBool(L *lua.LState) int {
+	var value bool
+	switch v := L.Get(1).(type) {
+	case lua.LNumber:
+		value = v != 0
+	case lua.LString:
+		value = v != ""
+	default:
+		value = lua.LVAsBool(v)
+	}
+	pushParam(L, ole.VT_BOOL, value)
+	return 1
+}
+
+var dateFields = []string{"year", "month", "day", "hour", "min", "sec", "msec"}
+
+// ToOleDate makes the date value for OLE parameter from the table
+// {year=,month=,day=,hour=,min=,sec=,msec=} (as the results of VT_DATE are)
+// or the numbers year,month,day[,hour,min,sec,msec] as the wall clock
+// in DateLocation, or in UTC when the table has utc=true.
+// The single number is the OLE Automation date itself (the days since
+// 1899-12-30 with the time as the fraction), which is sent as it is.
+func ToOleDate(L *lua.LState) int {
+	if n, ok := L.Get(1).(lua.LNumber); ok && L.GetTop() == 1 {
+		f := float64(n)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return lerror(L, fmt.Sprintf("ToOleDate: %v is not a date", f))
+		}
+		pushParam(L, ole.VT_DATE, f)
+		return 1
+	}
+	var fields [7]int
+	loc := DateLocation
+	if t, ok := L.Get(1).(*lua.LTable); ok {
+		for i, name := range dateFields {
+			value := L.GetField(t, name)
+			if n, ok := value.(lua.LNumber); ok {
+				fields[i] = int(n)
+			} else if i < 3 || value != lua.LNil {
+				return lerror(L, fmt.Sprintf("ToOleDate: field %s is not a number", name))
+			}
+		}
+		if lua.LVAsBool(L.GetField(t, "utc")) {
+			loc = time.UTC
+		}
+	} else {
+		for i, name := range dateFields {
+			value := L.Get(i + 1)
+			if n, ok := value.(lua.LNumber); ok {
+				fields[i] = int(n)
+			} else if i < 3 || value != lua.LNil {
+				return lerror(L, fmt.Sprintf("ToOleDate: %s is not a number", name))
+			}
+		}
+	}
+	L.Push(newDate(L, time.Date(fields[0], time.Month(fields[1]), fields[2],
+		fields[3], fields[4], fields[5], fields[6]*int(time.Millisecond), loc)))
+	return 1
+}
+
+type refT struct {
+	Value lua.LValue
+}
+
+// ToOleRef makes the parameter passed by reference (VT_VARIANT|VT_BYREF)
+// for [out] or [in,out] parameters. The methods return the values stored
+// into them after their own result.
+func ToOleRef(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = &refT{Value: L.Get(1)}
+	L.Push(ud)
+	return 1
+}
+
+// ToOleArray makes the array of the elements in the Lua table typed
+// by the 2nd argument: "r8" (or "double") for VT_ARRAY|VT_R8, "r4"
+// (or "single") for VT_ARRAY|VT_R4 and "variant" for VT_ARRAY|VT_VARIANT.
+// The 3rd argument is the lower bound of the indices (default 0) for
+// the servers expecting 1-based arrays like VB6's. t[1] is a(lower).
+func ToOleArray(L *lua.LState) int {
+	t, ok := L.Get(1).(*lua.LTable)
+	if !ok {
+		return lerror(L, "ToOleArray: 1st argument is not a table")
+	}
+	lower := int32(0)
+	if n, ok := L.Get(3).(lua.LNumber); ok {
+		lower = int32(n)
+		if lua.LNumber(lower) != n {
+			return lerror(L, fmt.Sprintf("ToOleArray: %v: invalid lower bound", n))
+		}
+	} else if L.Get(3) != lua.LNil {
+		return lerror(L, "ToOleArray: 3rd argument is not a number")
+	}
+	var vt ole.VT
+	switch typ := strings.ToLower(L.OptString(2, "r8")); typ {
+	case "r8", "double":
+		vt = ole.VT_R8
+	case "r4", "single":
+		vt = ole.VT_R4
+	case "variant":
+		if _, err := sequenceLen(t); err != nil {
+			return lerror(L, fmt.Sprintf("ToOleArray: %s", err.Error()))
+		}
+		ud := L.NewUserData()
+		ud.Value = &variantArrayT{table: t, lower: lower}
+		L.Push(ud)
+		return 1
+	default:
+		return lerror(L, fmt.Sprintf("ToOleArray: %s: unknown element type", typ))
+	}
+	n, err := sequenceLen(t)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("ToOleArray: %s", err.Error()))
+	}
+	values := make([]float64, n)
+	for i := range values {
+		num, ok := t.RawGetInt(i + 1).(lua.LNumber)
+		if !ok {
+			return lerror(L, fmt.Sprintf("ToOleArray: [%d] is not a number", i+1))
+		}
+		values[i] = float64(num)
+	}
+	ud := L.NewUserData()
+	ud.Value = &numberArrayT{vt: vt, values: values, lower: lower}
+	L.Push(ud)
+	return 1
+}
+
+// ToDictionary creates Scripting.Dictionary filled with the keys and
+// the values of the Lua table for the COM methods which want it.
+// It returns the object and nil, or nil and the error message.
+func ToDictionary(L *lua.LState) int {
+	if initializedRequired {
+		initialize(ole.COINIT_APARTMENTTHREADED)
+	}
+	table, ok := L.Get(1).(*lua.LTable)
+	if !ok {
+		return lerror(L, "ToDictionary: parameter not a table")
+	}
+	unknown, err := oleutil.CreateObject("Scripting.Dictionary")
+	if err != nil {
+		return lerror(L, fmt.Sprintf("oleutil.CreateObject: %s", err.Error()))
+	}
+	defer unknown.Release()
+	obj, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return lerror(L, fmt.Sprintf("unknown.QueryInterfce: %s", err.Error()))
+	}
+	dic := &capsuleT{Data: obj, dispIDs: &dispIDCache{ids: map[string]int32{}}}
+	var key, value lua.LValue = lua.LNil, lua.LNil
+	for {
+		key, value = table.Next(key)
+		if key == lua.LNil {
+			break
+		}
+		params := make([]interface{}, 2)
+		if params[0], err = lvalue2interface(L, key); err == nil {
+			params[1], err = lvalue2interface(L, value)
+		}
+		if err == nil {
+			var result *ole.VARIANT
+			result, err = dic.CallMethodByDispID("Add", params...)
+			if err == nil {
+				result.Clear()
+			}
+		}
+		freeParams(params)
+		if err != nil {
+			dic.release()
+			return lerror(L, fmt.Sprintf("ToDictionary: %s: %s", key.String(), err.Error()))
+		}
+	}
+	L.Push(dic.ToLValue(L))
+	L.Push(lua.LNil)
+	return 2
+}
+
+// Logger receives the error messages also returned to Lua for diagnostics.
+// It is nil (no output) by default. Set os.Stderr for the old behavior.
+var Logger io.Writer
+
+func logln(a ...interface{}) {
+	if Logger != nil {
+		fmt.Fprintln(Logger, a...)
+	}
+}
+
+// RaiseErrors makes the functions raise Lua errors which pcall can catch
+// instead of returning nil and the error. The errors are the same strings
+// or tables as the second values of the default mode.
+var RaiseErrors = false
+
+func lerror(L *lua.LState, s string) int {
+	if RaiseErrors {
+		logln(s)
+		L.RaiseError("%s", s)
+	}
+	L.Push(lua.LNil)
+	L.Push(lua.LString(s))
+	logln(s)
+	return 2
+}
+
+// resultToLValue converts the result of the call by variantToLValue.
+// The objects are moved to the value. The others (the arrays, BSTR and
+// so on) are copied, so it frees the result after the conversion.
+// On failure, it frees the result which may still hold the objects.
+func resultToLValue(L *lua.LState, v *ole.VARIANT) (lua.LValue, error) {
+	val, err := variantToLValue(L, v)
+	if err != nil || (v.VT != ole.VT_DISPATCH && v.VT != ole.VT_UNKNOWN) {
+		v.Clear()
+	}
+	return val, err
+}
+
+// releaseLValue releases the objects in the value converted partially
+// before the failure.
+func releaseLValue(val lua.LValue) {
+	switch v := val.(type) {
+	case *lua.LUserData:
+		if p, ok := v.Value.(*capsuleT); ok {
+			p.release()
+		}
+	case *lua.LTable:
+		v.ForEach(func(_, value lua.LValue) {
+			releaseLValue(value)
+		})
+	}
+}
+
+// variantToLValue converts the VARIANT into the Lua value.
+// The objects' references of v are moved into the results. On failure,
+// v is left as it is, and the objects are still owned by v.
+func variantToLValue(L *lua.LState, v *ole.VARIANT) (lua.LValue, error) {
+	if v.VT == ole.VT_VARIANT|ole.VT_BYREF {
+		// The inner VARIANT is owned by the server, so the objects
+		// are referred by AddRef instead of taking the reference.
+		inner := *(**ole.VARIANT)(unsafe.Pointer(&v.Val))
+		if inner == nil {
+			return lua.LNil, nil
+		}
+		isObject := (inner.VT == ole.VT_DISPATCH || inner.VT == ole.VT_UNKNOWN) && inner.Val != 0
+		if isObject {
+			inner.ToIUnknown().AddRef()
+		}
+		val, err := variantToLValue(L, inner)
+		if err != nil && isObject {
+			inner.ToIUnknown().Release()
+		}
+		return val, err
+	}
+	if VariantHook != nil {
+		if val := VariantHook(L, v); val != nil {
+			return val, nil
+		}
+	}
+	if v.VT&ole.VT_ARRAY != 0 {
+		return safeArrayToLValue(L, v)
+	}
 	switch v.VT {
 	case ole.VT_EMPTY, ole.VT_NULL:
 		return lua.LNil, nil
-	case ole.VT_I1:
-		return lua.LNumber(v.Value().(int)), nil
-	case ole.VT_UI1:
-		return lua.LNumber(v.Value().(uint8)), nil
-	case ole.VT_I2:
-		return lua.LNumber(v.Value().(int16)), nil
-	case ole.VT_UI2:
-		return lua.LNumber(v.Value().(uint16)), nil
-	case ole.VT_I4:
-		return lua.LNumber(v.Value().(int32)), nil
-	case ole.VT_UI4:
-		return lua.LNumber(v.Value().(uint32)), nil
-	case ole.VT_I8:
-		return lua.LNumber(v.Value().(int64)), nil
-	case ole.VT_UI8:
-		return lua.LNumber(v.Value().(uint64)), nil
-	case ole.VT_INT:
-		return lua.LNumber(v.Value().(int)), nil
-	case ole.VT_UINT:
-		return lua.LNumber(v.Value().(uint)), nil
-	case ole.VT_INT_PTR:
-		return lua.LNumber(v.Value().(uintptr)), nil
-	case ole.VT_UINT_PTR:
-		return lua.LNumber(v.Value().(uintptr)), nil
+	case ole.VT_I1, ole.VT_I2, ole.VT_I4, ole.VT_I8, ole.VT_INT, ole.VT_INT_PTR:
+		// LNumber of GopherLua is float64, but whole numbers are printed
+		// without the decimal point. (tostring(5) == "5")
+		n := variantToInt64(v)
+		if LargeIntegerAsString && (n > maxSafeInteger || n < -maxSafeInteger) {
+			return lua.LString(strconv.FormatInt(n, 10)), nil
+		}
+		return lua.LNumber(n), nil
+	case ole.VT_UI1, ole.VT_UI2, ole.VT_UI4, ole.VT_UI8, ole.VT_UINT, ole.VT_UINT_PTR:
+		n := variantToUint64(v)
+		if LargeIntegerAsString && n > maxSafeInteger {
+			return lua.LString(strconv.FormatUint(n, 10)), nil
+		}
+		return lua.LNumber(n), nil
 	case ole.VT_R4:
 		return lua.LNumber(v.Value().(float32)), nil
 	case ole.VT_R8:
 		return lua.LNumber(v.Value().(float64)), nil
+	case ole.VT_CY:
+		if CurrencyAsString {
+			return lua.LString(formatScaled(big.NewInt(v.Val), 4)), nil
+		}
+		return lua.LNumber(float64(v.Val) / 10000), nil
+	case ole.VT_DECIMAL:
+		text := formatScaled(decimalToBigInt(v))
+		if DecimalAsString {
+			return lua.LString(text), nil
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return lua.LNil, fmt.Errorf("variantToLValue: can not convert ole.VT_DECIMAL: %s", err.Error())
+		}
+		return lua.LNumber(f), nil
 	case ole.VT_BSTR:
-		return lua.LString(v.ToString()), nil
+		return lua.LString(bstrToString(*(**uint16)(unsafe.Pointer(&v.Val)))), nil
 	case ole.VT_DATE:
-		if date, ok := v.Value().(time.Time); ok {
-			t := L.NewTable()
-			L.SetField(t, "year", lua.LNumber(date.Year()))
-			L.SetField(t, "month", lua.LNumber(int(date.Month())))
-			L.SetField(t, "day", lua.LNumber(date.Day()))
-			L.SetField(t, "hour", lua.LNumber(date.Hour()))
-			L.SetField(t, "min", lua.LNumber(date.Minute()))
-			L.SetField(t, "sec", lua.LNumber(date.Second()))
-			return t, nil
-		} else if floatValue, ok := v.Value().(float64); ok {
-			return lua.LNumber(floatValue), nil
-		} else {
-			return lua.LNil, errors.New("variantToLValue: can not convert ole.VT_DATE")
+		date := oleDateToTime(math.Float64frombits(uint64(v.Val)), DateLocation)
+		if DateAsUserData {
+			return newDate(L, date), nil
+		}
+		t := L.NewTable()
+		L.SetField(t, "year", lua.LNumber(date.Year()))
+		L.SetField(t, "month", lua.LNumber(int(date.Month())))
+		L.SetField(t, "day", lua.LNumber(date.Day()))
+		L.SetField(t, "hour", lua.LNumber(date.Hour()))
+		L.SetField(t, "min", lua.LNumber(date.Minute()))
+		L.SetField(t, "sec", lua.LNumber(date.Second()))
+		L.SetField(t, "msec", lua.LNumber(date.Nanosecond()/int(time.Millisecond)))
+		if DateLocation == time.UTC {
+			L.SetField(t, "utc", lua.LTrue)
 		}
+		return t, nil
 	case ole.VT_DISPATCH:
-		return capsuleT{v.ToIDispatch()}.ToLValue(L), nil
+		disp := v.ToIDispatch()
+		if disp == nil {
+			// for example, Parent of the object which has none.
+			return lua.LNil, nil
+		}
+		return capsuleT{Data: disp}.ToLValue(L), nil
+	case ole.VT_UNKNOWN:
+		unknown := v.ToIUnknown()
+		if unknown == nil {
+			return lua.LNil, nil
+		}
+		disp, err := unknown.QueryInterface(ole.IID_IDispatch)
+		if err != nil {
+			return lua.LNil, fmt.Errorf("variantToLValue: VT_UNKNOWN: object does not support IDispatch: %s", err.Error())
+		}
+		unknown.Release()
+		return capsuleT{Data: disp}.ToLValue(L), nil
+	case ole.VT_ERROR:
+		// SCODE: for example, DISP_E_PARAMNOTFOUND for omitted optional arguments
+		code := uint32(v.Val)
+		t := L.NewTable()
+		L.SetField(t, "code", lua.LNumber(code))
+		if msg := ole.NewError(uintptr(code)).String(); msg != "" {
+			L.SetField(t, "message", lua.LString(msg))
+		}
+		return t, nil
 	case ole.VT_BOOL:
-		if v.Value().(bool) {
+		// VARIANT_BOOL is 16-bit (VARIANT_TRUE is -1), so the rest of Val is ignored.
+		if int16(v.Val) != 0 {
 			return lua.LTrue, nil
 		} else {
 			return lua.LFalse, nil
@@ -491,3 +2681,72 @@ func variantToLValue(L *lua.LState, v *ole.VARIANT) (lua.LValue, error) {
 		return lua.LNil, fmt.Errorf("variantToLValue: %v: not support", v.VT)
 	}
 }
+
+// variantToInt64 reads the signed integer of the size of the VT
+// (VT_INT is 32-bit even on 64-bit Windows)
+func variantToInt64(v *ole.VARIANT) int64 {
+	switch v.VT {
+	case ole.VT_I1:
+		return int64(int8(v.Val))
+	case ole.VT_I2:
+		return int64(int16(v.Val))
+	case ole.VT_I4, ole.VT_INT:
+		return int64(int32(v.Val))
+	case ole.VT_INT_PTR:
+		return int64(int(v.Val))
+	default:
+		return v.Val
+	}
+}
+
+// variantToUint64 reads the unsigned integer of the size of the VT
+func variantToUint64(v *ole.VARIANT) uint64 {
+	switch v.VT {
+	case ole.VT_UI1:
+		return uint64(uint8(v.Val))
+	case ole.VT_UI2:
+		return uint64(uint16(v.Val))
+	case ole.VT_UI4, ole.VT_UINT:
+		return uint64(uint32(v.Val))
+	case ole.VT_UINT_PTR:
+		return uint64(uintptr(v.Val))
+	default:
+		return uint64(v.Val)
+	}
+}
+
+// decimalToBigInt decodes DECIMAL which overlays the whole VARIANT:
+// wReserved(=VT), scale, sign, Hi32 and Lo64.
+// It returns the 96-bit integer with the sign and its scale.
+func decimalToBigInt(v *ole.VARIANT) (*big.Int, int) {
+	raw := (*[16]byte)(unsafe.Pointer(v))
+	scale := int(raw[2])
+	hi := binary.LittleEndian.Uint32(raw[4:8])
+	lo := binary.LittleEndian.Uint64(raw[8:16])
+
+	value := new(big.Int).SetUint64(uint64(hi))
+	value.Lsh(value, 64)
+	value.Or(value, new(big.Int).SetUint64(lo))
+	if raw[3]&0x80 != 0 {
+		value.Neg(value)
+	}
+	return value, scale
+}
+
+// formatScaled formats value/10^scale without the trailing zeros of decimals.
+func formatScaled(value *big.Int, scale int) string {
+	digits := new(big.Int).Abs(value).String()
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	intPart := digits[:len(digits)-scale]
+	fracPart := strings.TrimRight(digits[len(digits)-scale:], "0")
+	result := intPart
+	if fracPart != "" {
+		result = intPart + "." + fracPart
+	}
+	if value.Sign() < 0 {
+		result = "-" + result
+	}
+	return result
+}
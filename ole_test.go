@@ -1,6 +1,7 @@
 package ole_test
 
 import (
+	"runtime"
 	"strings"
 	"testing"
 
@@ -12,12 +13,19 @@ func newL() *lua.LState {
 	L := lua.NewState()
 	L.SetGlobal("create_object", L.NewFunction(ole.CreateObject))
 	L.SetGlobal("to_ole_integer", L.NewFunction(ole.ToOleInteger))
+	L.SetGlobal("pairs", L.NewFunction(ole.Pairs))
 	return L
 }
 
+// closeL closes COM too, because the goroutine of each test locks its own thread.
+func closeL(L *lua.LState) {
+	ole.Uninitialize(L)
+	L.Close()
+}
+
 func TestGc(t *testing.T) {
 	L := newL()
-	defer L.Close()
+	defer closeL(L)
 
 	err := L.DoString(`
 		local fsObj = create_object("Scripting.FileSystemObject")
@@ -37,3 +45,1288 @@ func TestGc(t *testing.T) {
 	}
 	// println(err.Error())
 }
+
+func TestReleased(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		assert(fsObj:_release())
+		assert(fsObj:_release())
+		local result, err = fsObj:GetDriveName("C:\\Windows")
+		assert(result == nil)
+		assert(string.find(err,"object already released",1,true))
+		for _,f in ipairs{
+			function() return fsObj:_call("GetDriveName","C:\\") end,
+			function() return fsObj:_get("Drives") end,
+		} do
+			local result, err = f()
+			assert(result == nil)
+			assert(string.find(err,"object already released",1,true))
+		end`)
+	if err != nil {
+		t.Fatalf("OBJ:METHOD() after OBJ:_release(): %s", err)
+	}
+}
+
+func TestSafeArray(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("a",1)
+		dic:Add("b",2)
+		local keys = dic:Keys()
+		assert(type(keys) == "table")
+		assert(#keys == 2)
+		assert(keys[1] == "a")
+		assert(keys[2] == "b")
+		dic:RemoveAll()
+		assert(#dic:Keys() == 0)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("Dictionary:Keys(): %s", err)
+	}
+}
+
+func TestTableParameter(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("list",{ "x","y","z" })
+		dic:Add("matrix",{ {1,2},{3,4},{5,6} })
+		local list = dic:_get("Item","list")
+		assert(#list == 3 and list[1] == "x" and list[3] == "z")
+		local matrix = dic:_get("Item","matrix")
+		assert(#matrix == 3 and #matrix[1] == 2)
+		assert(matrix[2][1] == 3 and matrix[3][2] == 6)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("Dictionary:Add(table): %s", err)
+	}
+
+	err = L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		local _, err = dic:Add("mixed",{ 1, 2, foo="bar" })
+		dic:_release()
+		assert(string.find(err,"mixes array and map keys"))`)
+	if err != nil {
+		t.Fatalf("Dictionary:Add(mixed table): %s", err)
+	}
+}
+
+func TestSetArray(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		assert(dic:_set("Item","matrix",{ {1,2,3},{4,5,6} }))
+		local matrix = dic:_get("Item","matrix")
+		assert(#matrix == 2 and #matrix[1] == 3)
+		for i = 1, 2 do
+			for j = 1, 3 do
+				assert(matrix[i][j] == (i-1)*3+j)
+			end
+		end
+		assert(dic:_set("Item","list",{ "x","y" }))
+		local list = dic:_get("Item","list")
+		assert(#list == 2 and list[1] == "x" and list[2] == "y")
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_set(PROPERTY,table): %s", err)
+	}
+}
+
+func TestBoolParameter(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("yes",true)
+		dic:Add("no",false)
+		dic:Add("flags",{ true,false,true })
+		assert(dic:_get("Item","yes") == true)
+		assert(dic:_get("Item","no") == false)
+		local flags = dic:_get("Item","flags")
+		assert(#flags == 3)
+		assert(flags[1] == true and flags[2] == false and flags[3] == true)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("Dictionary:Add(boolean): %s", err)
+	}
+}
+
+func TestExcepInfo(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local folder, err = fsObj:GetFolder("C:\\no\\such\\folder")
+		fsObj:_release()
+		assert(folder == nil)
+		assert(type(err) == "table")
+		assert(err.description and err.description ~= "")
+		assert(err.code ~= 0)
+		assert(err.hex == string.format("0x%08X",err.code))
+		assert(tostring(err) == err.message)`)
+	if err != nil {
+		t.Fatalf("EXCEPINFO: %s", err)
+	}
+}
+
+func TestComThread(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	for i := 0; i < 100; i++ {
+		err := L.DoString(`
+			local fsObj = create_object("Scripting.FileSystemObject")
+			assert(fsObj:GetDriveName("C:\\Windows") == "C:")
+			fsObj:_release()`)
+		if err != nil {
+			t.Fatalf("call %d failed: %s", i, err)
+		}
+		if !ole.IsComThread() {
+			t.Fatalf("call %d: not on the COM thread", i)
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestToString(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		assert(string.find(tostring(fsObj),"^ole%."))
+		local folder = fsObj:GetFolder("C:\\")
+		assert(tostring(folder) == "C:\\")
+		folder:_release()
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("tostring(OBJ): %s", err)
+	}
+}
+
+func TestLength(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("a",1)
+		dic:Add("b",2)
+		assert(#dic == 2)
+		dic:_release()
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local ok, err = pcall(function() return #fsObj end)
+		fsObj:_release()
+		assert(not ok and string.find(err,"no Count"))`)
+	if err != nil {
+		t.Fatalf("#OBJ: %s", err)
+	}
+}
+
+func TestPairs(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("a",1)
+		dic:Add("b",2)
+		local result = {}
+		for key,value in pairs(dic) do
+			result[key] = value
+		end
+		assert(result.a == 1 and result.b == 2)
+		dic:_release()
+
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local count = 0
+		local drives = fsObj:_get("Drives")
+		for i,drive in pairs(drives) do
+			count = count + 1
+			assert(i == count)
+			drive:_release()
+		end
+		drives:_release()
+		assert(count > 0)
+		assert(not pcall(pairs,fsObj))
+		fsObj:_release()
+
+		for key,value in pairs({x=1}) do
+			assert(key == "x" and value == 1)
+		end`)
+	if err != nil {
+		t.Fatalf("pairs(OBJ): %s", err)
+	}
+}
+
+func TestIndexDefault(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add(1,"one")
+		dic:Add(2,"two")
+		assert(dic[1] == "one")
+		assert(dic[2] == "two")
+		assert(dic:_default(1) == "one")
+		assert(dic(2) == "two")
+		assert(dic:_invoke(dic:_dispid("Item"),"propget",1) == "one")
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ[N]: %s", err)
+	}
+}
+
+func TestPreload(t *testing.T) {
+	L := lua.NewState()
+	ole.Preload(L)
+	defer closeL(L)
+
+	err := L.DoString(`
+		local ole = require("ole")
+		local fsObj = ole.create_object("Scripting.FileSystemObject")
+		assert(fsObj:GetDriveName("C:\\Windows") == "C:")
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("require(\"ole\"): %s", err)
+	}
+}
+
+func TestConstructors(t *testing.T) {
+	L := lua.NewState()
+	ole.Preload(L)
+	L.SetGlobal("constructors", L.NewFunction(ole.Constructors))
+	defer closeL(L)
+
+	err := L.DoString(`
+		local ole = require("ole")
+		local dic = ole.create_object("Scripting.Dictionary")
+		dic:Add("i",ole.Int64("9007199254740993"))
+		dic:Add("s",ole.String(123))
+		dic:Add("n",ole.Null())
+		assert(dic:_get("Item","s") == "123")
+		local val, isnull = dic:_getnull("Item","n")
+		assert(isnull)
+		dic:_release()
+		local c = constructors()
+		assert(type(c.Date) == "function" and type(c.Null) == "function")`)
+	if err != nil {
+		t.Fatalf("ole.Int64() and so on: %s", err)
+	}
+}
+
+func TestToOleInt64(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+	L.SetGlobal("to_ole_int64", L.NewFunction(ole.ToOleInt64))
+
+	ole.LargeIntegerAsString = true
+	defer func() { ole.LargeIntegerAsString = false }()
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("s",assert(to_ole_int64("9007199254740993")))
+		dic:Add("n",assert(to_ole_int64(-2^63)))
+		assert(dic:_get("Item","s") == "9007199254740993")
+		assert(dic:_get("Item","n") == "-9223372036854775808")
+		for _, n in ipairs{1.5, 0/0, math.huge, -math.huge, 2^63, -2^64} do
+			local value, err = to_ole_int64(n)
+			assert(value == nil and err ~= nil, tostring(n))
+		end
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("to_ole_int64(): %s", err)
+	}
+}
+
+func TestExports(t *testing.T) {
+	L := lua.NewState()
+	ole.Preload(L)
+	defer closeL(L)
+
+	err := L.DoString(`
+		local ole = require("ole")
+		for _, name in ipairs{"to_dictionary", "get_active_object", "to_ole_bool", "to_ole_array"} do
+			assert(type(ole[name]) == "function", name)
+		end`)
+	if err != nil {
+		t.Fatalf("require(\"ole\"): %s", err)
+	}
+}
+
+func BenchmarkIter(b *testing.B) {
+	L := newL()
+	defer closeL(L)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := L.DoString(`
+			local fsObj = create_object("Scripting.FileSystemObject")
+			local folder = fsObj:GetFolder("C:\\Windows\\System32")
+			local files = folder:_get("Files")
+			for f in files:_iter() do
+				f:_release()
+			end
+			files:_release()
+			folder:_release()
+			fsObj:_release()`)
+		if err != nil {
+			b.Fatalf("iterating Files: %s", err)
+		}
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local name = assert(fsObj:_typename())
+		assert(string.find(name,"FileSystem"))
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_typename(): %s", err)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local members = assert(fsObj:_methods())
+		local found = false
+		for _,m in ipairs(members) do
+			if m.name == "GetFolder" then
+				assert(m.kind == "method")
+				found = true
+			end
+		end
+		assert(found)
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_methods(): %s", err)
+	}
+}
+
+func TestMemberParameter(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local src = create_object("Scripting.Dictionary")
+		src:Add("a",1)
+		src:Add("b",2)
+		local dst = create_object("Scripting.Dictionary")
+		dst:Add("count",src.Count)
+		assert(dst:Item("count") == 2)
+		dst:_release()
+		src:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:METHOD(OBJ.member): %s", err)
+	}
+}
+
+func TestIndexedMemberParameter(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	// Item of Dictionary needs the key, so OBJ.Item.Count is not
+	// Count of the dictionary.
+	err := L.DoString(`
+		local src = create_object("Scripting.Dictionary")
+		src:Add("a",1)
+		local dst = create_object("Scripting.Dictionary")
+		local ok, result, err = pcall(function()
+			return dst:Add("count",src.Item.Count)
+		end)
+		if not ok then
+			err = result
+		end
+		assert(string.find(tostring(err),"Item needs the arguments",1,true))
+		assert(dst.Count == 0)
+		dst:_release()
+		src:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:METHOD(OBJ.INDEXED.member): %s", err)
+	}
+}
+
+func TestWith(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+	L.SetGlobal("with", L.NewFunction(ole.With))
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local folder
+		local drive = with(fsObj,function(fsObj)
+			folder = fsObj:GetFolder("C:\\")
+			return fsObj:GetDrive("C:")
+		end)
+		assert(select(2,fsObj:GetDriveName("C:\\")) ~= nil)
+		assert(select(2,folder:_get("Name")) ~= nil)
+		assert(drive:_get("DriveLetter") == "C")
+		drive:_release()
+
+		fsObj = create_object("Scripting.FileSystemObject")
+		assert(not pcall(with,fsObj,function(fsObj) error("fail") end))
+		assert(select(2,fsObj:GetDriveName("C:\\")) ~= nil)`)
+	if err != nil {
+		t.Fatalf("with(OBJ,function): %s", err)
+	}
+}
+
+func TestCallNamed(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		assert(fsObj:_callnamed("GetDriveName",{},{Path="C:\\Windows"}) == "C:")
+		assert(fsObj:_callnamed("GetDriveName",{"C:\\Windows"}) == "C:")
+		local none, err = fsObj:_callnamed("GetDriveName",{"C:\\Windows",nil,"x"})
+		assert(none == nil and string.find(err,"gap at [2]",1,true))
+		none, err = fsObj:_callnamed("GetDriveName",{"C:\\Windows",x=1})
+		assert(none == nil and string.find(err,"mixes array and map keys",1,true))
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_callnamed(): %s", err)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+	L.SetGlobal("to_ole_missing", L.NewFunction(ole.ToOleMissing))
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local path = fsObj:BuildPath(fsObj:GetSpecialFolder(2):_get("Path"),fsObj:GetTempName())
+		local file = assert(fsObj:CreateTextFile(path,to_ole_missing(),false))
+		file:Close()
+		file:_release()
+		fsObj:DeleteFile(path)
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("to_ole_missing(): %s", err)
+	}
+}
+
+func TestPumpMessages(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+	L.SetGlobal("pump_messages", L.NewFunction(ole.PumpMessages))
+
+	if err := L.DoString(`assert(pump_messages(10) == true)`); err != nil {
+		t.Fatalf("pump_messages(10): %s", err)
+	}
+}
+
+func TestRaiseErrors(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+	ole.RaiseErrors = true
+	defer func() { ole.RaiseErrors = false }()
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local ok, err = pcall(function() return fsObj:NoSuchMethod() end)
+		assert(not ok)
+		assert(err ~= nil)
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("ole.RaiseErrors: %s", err)
+	}
+}
+
+func TestIndexedSet(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("a","old")
+		assert(dic:_set("Item","a","new"))
+		assert(dic:Item("a") == "new")
+		assert(dic:_set("Item","b","added"))
+		assert(dic:Item("b") == "added")
+		local result, err = dic:_set("NoSuchProperty","x")
+		assert(result == nil and err ~= nil)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_set(PROPERTY,INDEX,VALUE): %s", err)
+	}
+}
+
+func TestSetObject(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local outer = create_object("Scripting.Dictionary")
+		local inner = create_object("Scripting.Dictionary")
+		inner:Add("x",1)
+		assert(outer:_set("Item","inner",inner))
+		local got = outer:Item("inner")
+		assert(tostring(got) == tostring(inner))
+		got:Add("y",2)
+		assert(inner:Exists("y"))
+		got:_release()
+		inner:_release()
+		outer:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_set(PROPERTY,OBJ): %s", err)
+	}
+}
+
+func TestQueryInterface(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		local disp = assert(dic:_queryinterface("{00020400-0000-0000-C000-000000000046}"))
+		disp:Add("a",1)
+		assert(dic:Exists("a"))
+		disp:_release()
+		assert(dic:_queryinterface("not a guid") == nil)
+		assert(dic:_queryinterface("{00000000-0000-0000-C000-000000000046}") == nil)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_queryinterface(): %s", err)
+	}
+}
+
+func TestNullObject(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		local result, err = dic.Count:Foo()
+		assert(result == nil)
+		assert(string.find(err,"operation on null COM object",1,true))
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("operation on null COM object: %s", err)
+	}
+
+	// get2 is __index, whose error values are dropped.
+	ole.RaiseErrors = true
+	defer func() { ole.RaiseErrors = false }()
+	err = L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		local ok, err = pcall(function() return dic.Count.Foo.Bar end)
+		assert(not ok)
+		assert(string.find(err,"operation on null COM object",1,true))
+		dic:_release()
+		ok, err = pcall(function() return dic.Keys.Count end)
+		assert(not ok)
+		assert(string.find(err,"object already released",1,true))`)
+	if err != nil {
+		t.Fatalf("OBJ.member.member on null COM object: %s", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		local copy = assert(dic:_clone())
+		dic:_release()
+		copy:Add("a",1)
+		assert(copy:Exists("a"))
+		copy:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_clone(): %s", err)
+	}
+}
+
+func TestEnum(t *testing.T) {
+	defer func(n int) { ole.EnumBatchSize = n }(ole.EnumBatchSize)
+	for _, size := range []int{1, 2, 32} {
+		ole.EnumBatchSize = size
+		testEnum(t)
+	}
+}
+
+func testEnum(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("a",1)
+		dic:Add("b",2)
+		dic:Add("c",3)
+		local e = assert(dic:_enum())
+		assert(e:_next() == "a")
+		assert(e:_skip(1))
+		assert(e:_next() == "c")
+		assert(e:_next() == nil)
+		assert(e:_reset())
+		local keys = {}
+		for key in e do
+			keys[#keys+1] = key
+		end
+		assert(table.concat(keys,",") == "a,b,c")
+		assert(e:_skip(5) == false)
+		e:_close()
+		assert(e:_next() == nil)
+		assert(e:_reset() == nil)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_enum() with EnumBatchSize=%d: %s", ole.EnumBatchSize, err)
+	}
+}
+
+func TestIterWithIndex(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("a",1)
+		dic:Add("b",2)
+		local keys = {}
+		for i,key in dic:_iter(true) do
+			keys[i] = key
+		end
+		assert(keys[1] == "a" and keys[2] == "b")
+		for key in dic:_iter() do
+			assert(type(key) == "string")
+		end
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_iter(true): %s", err)
+	}
+}
+
+func TestToArray(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		local empty = assert(dic:_toarray())
+		assert(#empty == 0)
+		dic:Add("b",1)
+		dic:Add("a",2)
+		local keys = assert(dic:_toarray())
+		table.sort(keys)
+		assert(table.concat(keys,",") == "a,b")
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_toarray(): %s", err)
+	}
+}
+
+func TestCreateObjectResults(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj, err = create_object("Scripting.FileSystemObject")
+		assert(fsObj ~= nil and err == nil)
+		assert(select("#",create_object("Scripting.FileSystemObject")) == 2)
+		fsObj:_release()
+		local none, err = create_object("No.Such.ProgID")
+		assert(none == nil and type(err) == "string")`)
+	if err != nil {
+		t.Fatalf("create_object(): %s", err)
+	}
+}
+
+func TestCreateObjectFromCLSID(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("create_object_from_clsid", L.NewFunction(ole.CreateObjectFromCLSID))
+	err := L.DoString(`
+		local dic, err = create_object_from_clsid("{EE09B103-97E0-11CF-978F-00A02463E06F}")
+		assert(dic ~= nil and err == nil, tostring(err))
+		dic:Add("a", 1)
+		assert(dic:_get("Count") == 1)
+		dic:_release()
+		local none, err = create_object_from_clsid("not a clsid")
+		assert(none == nil and type(err) == "string")`)
+	if err != nil {
+		t.Fatalf("create_object_from_clsid(): %s", err)
+	}
+}
+
+func TestCreateObjectLocation(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic, err = create_object("Scripting.Dictionary","")
+		assert(dic ~= nil and err == nil, tostring(err))
+		dic:_release()
+		dic, err = create_object("Scripting.Dictionary",nil)
+		assert(dic ~= nil and err == nil, tostring(err))
+		dic:_release()
+		local none
+		none, err = create_object("Scripting.Dictionary",1)
+		assert(none == nil and string.find(err,"location not a string",1,true))
+		none, err = create_object("Scripting.Dictionary","","x")
+		assert(none == nil and string.find(err,"too many parameters",1,true))
+		-- the ProgID is resolved locally before connecting to the server.
+		none, err = create_object("No.Such.ProgID","server")
+		assert(none == nil and string.find(err,"CreateObject: No.Such.ProgID:",1,true))`)
+	if err != nil {
+		t.Fatalf("create_object(progid,location): %s", err)
+	}
+}
+
+func TestToDictionary(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("to_dictionary", L.NewFunction(ole.ToDictionary))
+	err := L.DoString(`
+		local dic, err = to_dictionary({ name="foo", size=3, list={1,2} })
+		assert(dic ~= nil and err == nil, tostring(err))
+		assert(dic:_get("Count") == 3)
+		assert(dic:_get("Item","name") == "foo")
+		assert(dic:_get("Item","size") == 3)
+		assert(dic:_get("Item","list")[2] == 2)
+		dic:_release()
+		local none, err = to_dictionary("foo")
+		assert(none == nil and type(err) == "string")`)
+	if err != nil {
+		t.Fatalf("to_dictionary(): %s", err)
+	}
+}
+
+func TestMember(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:_member("Add")(dic,"a",1)
+		assert(dic:_member("Item")(dic,"a") == 1)
+		local _, err = dic:_member(1)
+		assert(type(err) == "string")
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_member(): %s", err)
+	}
+}
+
+func TestNewCallback(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("new_callback", L.NewFunction(ole.NewCallback))
+	err := L.DoString(`
+		local cb, err = new_callback(function(a,b) return a+b end)
+		assert(cb ~= nil and err == nil, tostring(err))
+		assert(cb(1,2) == 3)
+		assert(cb:Run(3,4) == 7)
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("f",cb)
+		local f = dic:_get("Item","f")
+		assert(f(5,6) == 11)
+		f:_release()
+		dic:_release()
+		cb:_release()
+
+		local bad = new_callback(function() error("boom") end)
+		local val, err = bad()
+		assert(val == nil and string.find(tostring(err),"boom"))
+		bad:_release()`)
+	if err != nil {
+		t.Fatalf("new_callback(): %s", err)
+	}
+}
+
+func TestGetNull(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("to_ole_null", L.NewFunction(ole.ToOleNull))
+	L.SetGlobal("to_ole_empty", L.NewFunction(ole.ToOleEmpty))
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("null",to_ole_null())
+		dic:Add("empty",to_ole_empty())
+		dic:Add("one",1)
+		assert(dic:_get("Item","null") == nil and dic:_get("Item","empty") == nil)
+		local val, isnull = dic:_getnull("Item","null")
+		assert(val == nil and isnull == true)
+		val, isnull = dic:_getnull("Item","empty")
+		assert(val == nil and isnull == false)
+		val, isnull = dic:_getnull("Item","one")
+		assert(val == 1 and isnull == false)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_getnull(): %s", err)
+	}
+}
+
+func TestTypedGet(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("to_ole_integer", L.NewFunction(ole.ToOleInteger))
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("int",to_ole_integer(7))
+		dic:Add("float",1.5)
+		dic:Add("str","foo")
+		dic:Add("bool",true)
+		assert(dic:_get_int("Item","int") == 7)
+		assert(dic:_get_number("Item","int") == 7)
+		assert(dic:_get_number("Item","float") == 1.5)
+		assert(dic:_get_string("Item","str") == "foo")
+		assert(dic:_get_bool("Item","bool") == true)
+		local val, err = dic:_get_int("Item","float")
+		assert(val == nil and string.find(err,"_get_int"))
+		val, err = dic:_get_number("Item","str")
+		assert(val == nil and err)
+		val, err = dic:_get_string("Item","int")
+		assert(val == nil and err)
+		val, err = dic:_get_bool("Item","str")
+		assert(val == nil and err)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_get_TYPE(): %s", err)
+	}
+}
+
+func TestMessageFilter(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("register_message_filter", L.NewFunction(ole.RegisterMessageFilter))
+	L.SetGlobal("unregister_message_filter", L.NewFunction(ole.UnregisterMessageFilter))
+	err := L.DoString(`
+		assert(register_message_filter(1000))
+		assert(register_message_filter())
+		local fsObj = create_object("Scripting.FileSystemObject")
+		assert(fsObj:FolderExists("C:\\"))
+		fsObj:_release()
+		assert(unregister_message_filter())
+		assert(unregister_message_filter())`)
+	if err != nil {
+		t.Fatalf("register_message_filter(): %s", err)
+	}
+}
+
+func TestSetReturnsPrevious(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	ole.SetReturnsPrevious = true
+	defer func() { ole.SetReturnsPrevious = false }()
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("a","old")
+		local ok, err, previous = dic:_set("Item","a","new")
+		assert(ok and err == nil and previous == "old")
+		assert(dic:Item("a") == "new")
+		ok, err, previous = dic:_set("Item","b","added")
+		assert(ok and previous == nil)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_set() with SetReturnsPrevious: %s", err)
+	}
+}
+
+func TestToOleInteger(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("n",to_ole_integer(10,"extra"))
+		assert(dic:_get("Item","n") == 10)
+		dic:_release()
+		local n, err = to_ole_integer({})
+		assert(n == nil and string.find(err,"not a number"))
+		dic = create_object("Scripting.Dictionary")
+		dic:Add("s",to_ole_integer(" 42 "))
+		dic:Add("x",to_ole_integer("0x10"))
+		assert(dic:_get("Item","s") == 42 and dic:_get("Item","x") == 16)
+		dic:_release()
+		n, err = to_ole_integer("abc")
+		assert(n == nil and string.find(err,"not a number"))
+		n, err = to_ole_integer(4294967296)
+		assert(n == nil and string.find(err,"out of range"))`)
+	if err != nil {
+		t.Fatalf("to_ole_integer(): %s", err)
+	}
+}
+
+func TestToOleArray(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("to_ole_array", L.NewFunction(ole.ToOleArray))
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("r8",to_ole_array({ 1.5, -2.25, 1e100 }))
+		dic:Add("r4",to_ole_array({ 0.5, 3 },"r4"))
+		local r8 = dic:_get("Item","r8")
+		assert(#r8 == 3 and r8[1] == 1.5 and r8[2] == -2.25 and r8[3] == 1e100)
+		local r4 = dic:_get("Item","r4")
+		assert(#r4 == 2 and r4[1] == 0.5 and r4[2] == 3)
+		dic:_release()
+		local a, err = to_ole_array({ 1, "x" })
+		assert(a == nil and string.find(err,"not a number"))
+		a, err = to_ole_array({ 1 },"i4")
+		assert(a == nil and string.find(err,"unknown element type"))
+		dic = create_object("Scripting.Dictionary")
+		dic:Add("one",to_ole_array({ "x","y" },"variant",1))
+		local one = dic:_get("Item","one")
+		assert(#one == 2 and one[1] == "x" and one[2] == "y")
+		local _, err = dic:Add("gap",{ 1, nil, 3 })
+		assert(string.find(err,"gap at [2]",1,true))
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("to_ole_array(): %s", err)
+	}
+}
+
+func TestGetActiveObject(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("get_active_object", L.NewFunction(ole.GetActiveObject))
+	err := L.DoString(`
+		local obj, err = get_active_object("Scripting.Dictionary")
+		assert(obj == nil and err.hex == "0x800401E3", tostring(err))
+		obj, err = get_active_object("No.Such.ProgID")
+		assert(obj == nil and err ~= nil)`)
+	if err != nil {
+		t.Fatalf("get_active_object(): %s", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local args = {}
+		table.insert(args,"C:\\Windows")
+		table.insert(args,"System32")
+		assert(fsObj:_apply("BuildPath",args) == "C:\\Windows\\System32")
+		assert(fsObj:_apply("GetDriveName",{"C:\\Windows"}) == "C:")
+		local _, err = fsObj:_apply("BuildPath",{ x=1 })
+		assert(string.find(err,"mixes array and map keys"))
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_apply(): %s", err)
+	}
+}
+
+func TestNotEnumerable(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local e, err = fsObj:_iter()
+		assert(e == nil and string.find(err,"not enumerable (no _NewEnum)",1,true), err)
+		e, err = fsObj:_enum()
+		assert(e == nil and string.find(err,"not enumerable",1,true))
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_iter() for non-collection: %s", err)
+	}
+}
+
+func TestItems(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("a",1)
+		dic:Add("b",2)
+		local keys, sum = "", 0
+		for key,value in dic:_items() do
+			keys = keys .. key
+			sum = sum + value
+		end
+		assert(keys == "ab" and sum == 3)
+		dic:_release()
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local next, err = fsObj:_items()
+		assert(next == nil and string.find(err,"no Keys"))
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_items(): %s", err)
+	}
+}
+
+func TestGetOr(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	var log strings.Builder
+	ole.Logger = &log
+	defer func() { ole.Logger = nil }()
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("a",1)
+		assert(dic:_getor("Count",0) == 1)
+		assert(dic:_getor("NoSuchProperty","default") == "default")
+		assert(dic:_getor("Item","a",0) == 1)
+		local _, err = dic:_getor("Count")
+		assert(string.find(err,"no default value"))
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_getor(): %s", err)
+	}
+	if strings.Contains(log.String(), "NoSuchProperty") {
+		t.Fatalf("OBJ:_getor() logged the error: %s", log.String())
+	}
+}
+
+func TestToOleBool(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("to_ole_bool", L.NewFunction(ole.ToOleBool))
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		local cases = { {1,true}, {0,false}, {"",false}, {"no",true}, {false,false} }
+		for i,c in ipairs(cases) do
+			dic:Add(i,to_ole_bool(c[1]))
+			assert(dic:_get("Item",i) == c[2], i)
+		end
+		dic:Add("nil",to_ole_bool(nil))
+		assert(dic:_get("Item","nil") == false)
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("to_ole_bool(): %s", err)
+	}
+}
+
+func TestNestedSet(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	L.SetGlobal("new_callback", L.NewFunction(ole.NewCallback))
+	err := L.DoString(`
+		-- like excel.ActiveCell.Interior.Color = 255:
+		-- app.Range returns range, range.Interior returns dic.
+		local dic = create_object("Scripting.Dictionary")
+		local range = new_callback(function() return dic end)
+		local app = new_callback(function() return range end)
+		app.Range.Interior.CompareMode = 1
+		assert(dic:_get("CompareMode") == 1)
+		app:_release()
+		range:_release()
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ.member.member = value: %s", err)
+	}
+}
+
+func TestIndexedChain(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local outer = create_object("Scripting.Dictionary")
+		local inner = create_object("Scripting.Dictionary")
+		inner:Add("a",1)
+		inner:Add("b",2)
+		outer:Add("key",inner)
+		-- Item requires the key, so it is read by the call with it.
+		assert(outer.Item.Count("key") == 2)
+		assert(outer.Item:Count("key") == 2)
+		assert(outer.Item.Count.Foo == nil)
+		inner:_release()
+		outer:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ.INDEXED.member(args): %s", err)
+	}
+}
+
+func TestRefCount(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		local n = dic:_refcount()
+		assert(dic:_addref() == n + 1)
+		assert(dic:_refcount() == n + 1)
+		local c = dic:_clone()
+		assert(dic:_refcount() == n + 2)
+		c:_release()
+		assert(dic:_refcount() == n + 1)
+		-- _release releases the reference of _addref too.
+		c = dic:_clone()
+		dic:_release()
+		assert(c:_refcount() == n)
+		c:_release()
+		local _, err = dic:_refcount()
+		assert(string.find(err,"already released",1,true))`)
+	if err != nil {
+		t.Fatalf("OBJ:_addref()/_refcount(): %s", err)
+	}
+}
+
+func TestIndependentIterators(t *testing.T) {
+	defer func(n int) { ole.EnumBatchSize = n }(ole.EnumBatchSize)
+	for _, size := range []int{1, 2} {
+		ole.EnumBatchSize = size
+		testIndependentIterators(t)
+	}
+}
+
+func testIndependentIterators(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local letters = create_object("Scripting.Dictionary")
+		letters:Add("a",1)
+		letters:Add("b",2)
+		letters:Add("c",3)
+		local digits = create_object("Scripting.Dictionary")
+		digits:Add("1",1)
+		digits:Add("2",2)
+		-- nested loops over the different collections
+		local got = {}
+		for x in letters:_iter() do
+			for y in digits:_iter() do
+				got[#got+1] = x .. y
+			end
+		end
+		assert(table.concat(got,",") == "a1,a2,b1,b2,c1,c2", table.concat(got,","))
+		-- two iterators advanced alternately, over the same collection too
+		local f1, s1 = letters:_iter()
+		local f2, s2 = digits:_iter()
+		local f3, s3 = letters:_iter()
+		assert(f1(s1) == "a" and f2(s2) == "1" and f3(s3) == "a")
+		assert(f1(s1) == "b" and f2(s2) == "2" and f1(s1) == "c")
+		assert(f2(s2) == nil and f3(s3) == "b" and f1(s1) == nil)
+		assert(f3(s3) == "c" and f3(s3) == nil)
+		digits:_release()
+		letters:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_iter() with EnumBatchSize=%d: %s", ole.EnumBatchSize, err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		local count = fsObj:_path("Drives.Count")
+		assert(type(count) == "number" and count > 0)
+		local none, err = fsObj:_path("Drives.NoSuchMember")
+		assert(none == nil and string.find(tostring(err),"_path: Drives.NoSuchMember:",1,true))
+		none, err = fsObj:_path("Drives.Count.Foo")
+		assert(none == nil and string.find(err,"_path: Drives.Count:",1,true))
+		none, err = fsObj:_path("Drives..Count")
+		assert(none == nil and string.find(err,"empty name",1,true))
+		fsObj:_release()`)
+	if err != nil {
+		t.Fatalf("OBJ:_path(): %s", err)
+	}
+}
+
+func TestMultibyteResult(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("name","日本語のファイル名.txt")
+		dic:Add("emoji","😀")
+		assert(dic:_get("Item","name") == "日本語のファイル名.txt")
+		assert(dic:_get_string("Item","name") == "日本語のファイル名.txt")
+		assert(dic:_get("Item","emoji") == "😀")
+		local values = dic:Items()
+		assert(values[1] == "日本語のファイル名.txt")
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("BSTR to UTF-8: %s", err)
+	}
+}
+
+func TestMultibyteParameter(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		local fsObj = create_object("Scripting.FileSystemObject")
+		assert(fsObj:GetFileName("C:\\フォルダ\\日本語.txt") == "日本語.txt")
+		assert(fsObj:GetBaseName("C:\\フォルダ\\日本語.txt") == "日本語")
+		assert(fsObj:GetExtensionName("表.データ") == "データ")
+		fsObj:_release()
+		local dic = create_object("Scripting.Dictionary")
+		dic:Add("キー","値")
+		assert(dic:Exists("キー"))
+		assert(not dic:Exists("キ"))
+		dic:_release()`)
+	if err != nil {
+		t.Fatalf("UTF-8 to BSTR: %s", err)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	L := newL()
+	defer closeL(L)
+
+	err := L.DoString(`
+		dic = create_object("Scripting.Dictionary")
+		assert(dic:_supports("{00020400-0000-0000-C000-000000000046}") == true) -- IDispatch
+		assert(dic:_supports("{00000000-0000-0000-C000-000000000046}") == true) -- IUnknown
+		assert(dic:_supports("{00000001-0000-0000-8000-00AA00000001}") == false)
+		local none, err = dic:_supports("not a guid")
+		assert(none == nil and string.find(err,"invalid GUID",1,true))
+		none, err = dic:_is_a("No.Such.ProgID")
+		assert(none == nil and err ~= nil)`)
+	if err != nil {
+		t.Fatalf("OBJ:_supports(): %s", err)
+	}
+	defer L.DoString(`dic:_release()`)
+
+	// _is_a needs the CLSID by IPersist or IProvideClassInfo.
+	if err := L.DoString(`_, class_err = dic:_is_a("Scripting.Dictionary")`); err != nil {
+		t.Fatalf("OBJ:_is_a(): %s", err)
+	}
+	if e := L.GetGlobal("class_err"); e != lua.LNil {
+		t.Skipf("OBJ:_is_a(): the CLSID of Scripting.Dictionary is not available: %s", e)
+	}
+	err = L.DoString(`
+		assert(dic:_is_a("Scripting.Dictionary") == true)
+		assert(dic:_is_a("Scripting.FileSystemObject") == false)
+		assert(dic:_is_a("{EE09B103-97E0-11CF-978F-00A02463E06F}") == true)`)
+	if err != nil {
+		t.Fatalf("OBJ:_is_a(): %s", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+import (
+	"time"
+)
+
+func pumpMessages(timeout time.Duration) bool {
+	time.Sleep(timeout)
+	return true
+}
@@ -0,0 +1,64 @@
+package ole
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	moduser32 = syscall.NewLazyDLL("user32.dll")
+
+	procPeekMessageW              = moduser32.NewProc("PeekMessageW")
+	procTranslateMessage          = moduser32.NewProc("TranslateMessage")
+	procDispatchMessageW          = moduser32.NewProc("DispatchMessageW")
+	procMsgWaitForMultipleObjects = moduser32.NewProc("MsgWaitForMultipleObjects")
+)
+
+const (
+	pmRemove   = 0x0001
+	qsAllInput = 0x04FF
+	wmQuit     = 0x0012
+	infinite   = 0xFFFFFFFF
+)
+
+type msgT struct {
+	hwnd     uintptr
+	message  uint32
+	wParam   uintptr
+	lParam   uintptr
+	time     uint32
+	ptX      int32
+	ptY      int32
+	lPrivate uint32
+}
+
+// pumpMessages dispatches the window messages until the timeout passes
+// (negative means forever) or WM_QUIT comes.
+// It returns false on WM_QUIT.
+func pumpMessages(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		var msg msgT
+		for {
+			r, _, _ := procPeekMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0, pmRemove)
+			if r == 0 {
+				break
+			}
+			if msg.message == wmQuit {
+				return false
+			}
+			procTranslateMessage.Call(uintptr(unsafe.Pointer(&msg)))
+			procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
+		}
+		wait := uintptr(infinite)
+		if timeout >= 0 {
+			rest := time.Until(deadline)
+			if rest <= 0 {
+				return true
+			}
+			wait = uintptr(rest / time.Millisecond)
+		}
+		procMsgWaitForMultipleObjects.Call(0, 0, 0, wait, qsAllInput)
+	}
+}
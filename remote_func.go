@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+import (
+	"github.com/go-ole/go-ole"
+)
+
+func createRemoteInstance(clsid *ole.GUID, server string) (*ole.IDispatch, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
@@ -0,0 +1,54 @@
+package ole
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var (
+	modole32 = syscall.NewLazyDLL("ole32.dll")
+
+	procCoCreateInstanceEx = modole32.NewProc("CoCreateInstanceEx")
+)
+
+const clsctxRemoteServer = 0x10
+
+type coServerInfo struct {
+	dwReserved1 uint32
+	pwszName    *uint16
+	pAuthInfo   uintptr
+	dwReserved2 uint32
+}
+
+type multiQI struct {
+	pIID *ole.GUID
+	pItf *ole.IUnknown
+	hr   uintptr
+}
+
+// createRemoteInstance creates the object of the class on the server
+// by CoCreateInstanceEx with COSERVERINFO and returns its IDispatch.
+func createRemoteInstance(clsid *ole.GUID, server string) (*ole.IDispatch, error) {
+	name, err := syscall.UTF16PtrFromString(server)
+	if err != nil {
+		return nil, err
+	}
+	info := coServerInfo{pwszName: name}
+	qi := multiQI{pIID: ole.IID_IDispatch}
+	hr, _, _ := procCoCreateInstanceEx.Call(
+		uintptr(unsafe.Pointer(clsid)),
+		0,
+		clsctxRemoteServer,
+		uintptr(unsafe.Pointer(&info)),
+		1,
+		uintptr(unsafe.Pointer(&qi)))
+	if hr != 0 {
+		return nil, ole.NewError(hr)
+	}
+	if int32(qi.hr) != 0 {
+		return nil, ole.NewError(uintptr(uint32(qi.hr)))
+	}
+	return (*ole.IDispatch)(unsafe.Pointer(qi.pItf)), nil
+}
@@ -0,0 +1,45 @@
+package ole
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-ole/go-ole"
+)
+
+func TestRetryBusy(t *testing.T) {
+	count, delay := RetryCount, RetryDelay
+	defer func() { RetryCount, RetryDelay = count, delay }()
+	RetryCount, RetryDelay = 3, time.Millisecond
+
+	calls := 0
+	result, err := retryBusy(func() (*ole.VARIANT, error) {
+		calls++
+		if calls < 3 {
+			return nil, ole.NewError(rpcECallRejected)
+		}
+		v := ole.NewVariant(ole.VT_I4, 1)
+		return &v, nil
+	})
+	if err != nil || result == nil || calls != 3 {
+		t.Fatalf("retryBusy()=%v,%v after %d calls (expected success after 3)", result, err, calls)
+	}
+
+	calls = 0
+	_, err = retryBusy(func() (*ole.VARIANT, error) {
+		calls++
+		return nil, ole.NewError(rpcEServerCallRetryLater)
+	})
+	if err == nil || calls != 4 {
+		t.Fatalf("retryBusy() gave up after %d calls with %v (expected 4 calls and the error)", calls, err)
+	}
+
+	calls = 0
+	_, err = retryBusy(func() (*ole.VARIANT, error) {
+		calls++
+		return nil, ole.NewError(ole.E_FAIL)
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("retryBusy() retried the other error %d times", calls-1)
+	}
+}
@@ -0,0 +1,302 @@
+package ole
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/yuin/gopher-lua"
+)
+
+// safeArrayToLValue converts VT_ARRAY|xx into 1-based Lua table.
+// Multi-dimensional arrays are nested dimension by dimension,
+// so that t[i][j] is the element a(i,j) .
+// The array remains in v, so the caller destroys it.
+// VT_ARRAY|VT_BYREF refers to the array by SAFEARRAY**.
+func safeArrayToLValue(L *lua.LState, v *ole.VARIANT) (lua.LValue, error) {
+	var sa *ole.SafeArray
+	if v.VT&ole.VT_BYREF != 0 {
+		if ref := *(***ole.SafeArray)(unsafe.Pointer(&v.Val)); ref != nil {
+			sa = *ref
+		}
+	} else if sac := v.ToArray(); sac != nil {
+		sa = sac.Array
+	}
+	if sa == nil {
+		return L.NewTable(), nil
+	}
+	vt := v.VT &^ (ole.VT_ARRAY | ole.VT_BYREF)
+	dims := safeArrayGetDim(sa)
+	if dims <= 0 {
+		return L.NewTable(), nil
+	}
+	lower := make([]int32, dims)
+	upper := make([]int32, dims)
+	for d := 0; d < dims; d++ {
+		var err error
+		lower[d], upper[d], err = safeArrayGetBounds(sa, d+1)
+		if err != nil {
+			return lua.LNil, fmt.Errorf("safeArrayToLValue: %s", err.Error())
+		}
+	}
+	// VariantHook has to see each element, so it disables the direct reading.
+	if dims == 1 && VariantHook == nil && (vt == ole.VT_BSTR || vt == ole.VT_R8 || vt == ole.VT_R4) {
+		return vectorToLValue(L, sa, vt, int(upper[0]-lower[0]+1))
+	}
+	indices := make([]int32, dims)
+	return safeArrayDimToLValue(L, sa, vt, 0, lower, upper, indices)
+}
+
+// vectorToLValue converts the one-dimensional arrays of VT_BSTR (such as
+// the lists of the file names), VT_R8 and VT_R4 reading the elements
+// directly, instead of copying each of them into a VARIANT by
+// SafeArrayGetElement.
+func vectorToLValue(L *lua.LState, sa *ole.SafeArray, vt ole.VT, n int) (lua.LValue, error) {
+	t := L.CreateTable(n, 0)
+	if n <= 0 {
+		return t, nil
+	}
+	data, err := safeArrayAccessData(sa)
+	if err != nil {
+		return lua.LNil, fmt.Errorf("safeArrayToLValue: %s", err.Error())
+	}
+	defer safeArrayUnaccessData(sa)
+	switch vt {
+	case ole.VT_BSTR:
+		for i, bstr := range (*[1 << 28]*uint16)(data)[:n:n] {
+			t.RawSetInt(i+1, lua.LString(bstrToString(bstr)))
+		}
+	case ole.VT_R8:
+		for i, f := range (*[1 << 27]float64)(data)[:n:n] {
+			t.RawSetInt(i+1, lua.LNumber(f))
+		}
+	case ole.VT_R4:
+		for i, f := range (*[1 << 28]float32)(data)[:n:n] {
+			t.RawSetInt(i+1, lua.LNumber(f))
+		}
+	}
+	return t, nil
+}
+
+// numberArrayT is the parameter made by to_ole_array,
+// which becomes VT_ARRAY|VT_R8 or VT_ARRAY|VT_R4 on the call.
+type numberArrayT struct {
+	vt     ole.VT
+	values []float64
+	lower  int32
+}
+
+// variantArrayT is the parameter made by to_ole_array(table,"variant",lower),
+// which becomes VT_ARRAY|VT_VARIANT whose lower bound is given.
+type variantArrayT struct {
+	table *lua.LTable
+	lower int32
+}
+
+// toVariant makes the SAFEARRAY. The caller has to Clear() the result.
+func (a *numberArrayT) toVariant() (*ole.VARIANT, error) {
+	sa, err := safeArrayCreate(a.vt, []ole.SafeArrayBound{{Elements: uint32(len(a.values)), LowerBound: a.lower}})
+	if err != nil {
+		return nil, fmt.Errorf("lua2interface: SafeArrayCreate: %s", err.Error())
+	}
+	if n := len(a.values); n > 0 {
+		data, err := safeArrayAccessData(sa)
+		if err != nil {
+			safeArrayDestroy(sa)
+			return nil, fmt.Errorf("lua2interface: SafeArrayAccessData: %s", err.Error())
+		}
+		if a.vt == ole.VT_R4 {
+			elems := (*[1 << 28]float32)(data)[:n:n]
+			for i, f := range a.values {
+				elems[i] = float32(f)
+			}
+		} else {
+			copy((*[1 << 27]float64)(data)[:n:n], a.values)
+		}
+		safeArrayUnaccessData(sa)
+	}
+	v := ole.NewVariant(ole.VT_ARRAY|a.vt, int64(uintptr(unsafe.Pointer(sa))))
+	return &v, nil
+}
+
+func safeArrayDimToLValue(L *lua.LState, sa *ole.SafeArray, vt ole.VT, dim int, lower, upper, indices []int32) (lua.LValue, error) {
+	t := L.NewTable()
+	last := len(indices) - 1
+	for i := lower[dim]; i <= upper[dim]; i++ {
+		// SafeArrayGetElement wants the right-most dimension first.
+		indices[last-dim] = i
+		var val lua.LValue
+		var err error
+		if dim < last {
+			val, err = safeArrayDimToLValue(L, sa, vt, dim+1, lower, upper, indices)
+		} else {
+			val, err = safeArrayElementToLValue(L, sa, vt, indices)
+		}
+		if err != nil {
+			releaseLValue(t)
+			return lua.LNil, err
+		}
+		t.RawSetInt(int(i-lower[dim])+1, val)
+	}
+	return t, nil
+}
+
+func safeArrayElementToLValue(L *lua.LState, sa *ole.SafeArray, vt ole.VT, indices []int32) (lua.LValue, error) {
+	var elem ole.VARIANT
+	var err error
+	switch vt {
+	case ole.VT_VARIANT:
+		err = safeArrayGetElement(sa, indices, unsafe.Pointer(&elem))
+	case ole.VT_I1, ole.VT_UI1, ole.VT_I2, ole.VT_UI2, ole.VT_I4, ole.VT_UI4,
+		ole.VT_I8, ole.VT_UI8, ole.VT_INT, ole.VT_UINT, ole.VT_R4, ole.VT_R8,
+		ole.VT_CY, ole.VT_DATE, ole.VT_BSTR, ole.VT_DISPATCH, ole.VT_ERROR,
+		ole.VT_BOOL, ole.VT_UNKNOWN:
+		err = safeArrayGetElement(sa, indices, unsafe.Pointer(&elem.Val))
+		elem.VT = vt
+	default:
+		return lua.LNil, fmt.Errorf("safeArrayToLValue: %v: not support", vt)
+	}
+	if err != nil {
+		return lua.LNil, fmt.Errorf("safeArrayToLValue: %s", err.Error())
+	}
+	if vt == ole.VT_UNKNOWN && elem.Val != 0 {
+		// The elements without IDispatch are nil, keeping the indices of the others.
+		unknown := elem.ToIUnknown()
+		disp, err := unknown.QueryInterface(ole.IID_IDispatch)
+		unknown.Release()
+		if err != nil {
+			return lua.LNil, nil
+		}
+		return capsuleT{Data: disp}.ToLValue(L), nil
+	}
+	val, err := variantToLValue(L, &elem)
+	if err != nil || (elem.VT != ole.VT_DISPATCH && elem.VT != ole.VT_UNKNOWN) {
+		// variantToLValue owns the references of objects,
+		// others (and the objects on failure) are copies to be freed.
+		elem.Clear()
+	}
+	return val, err
+}
+
+// sequenceLen returns n when the keys of the table are 1..n only.
+// The indices 1..n become 0..n-1 (or the lower bound given) of SAFEARRAY,
+// so the tables with the gaps like {1,nil,3} are errors.
+func sequenceLen(t *lua.LTable) (int, error) {
+	count, max := 0, 0
+	var err error
+	t.ForEach(func(key, _ lua.LValue) {
+		if num, ok := key.(lua.LNumber); ok {
+			i := int(num)
+			if lua.LNumber(i) == num && i >= 1 {
+				count++
+				if i > max {
+					max = i
+				}
+				return
+			}
+		}
+		err = errors.New("lua2interface: table mixes array and map keys")
+	})
+	if err != nil {
+		return 0, err
+	}
+	if count != max {
+		for i := 1; i <= max; i++ {
+			if t.RawGetInt(i) == lua.LNil {
+				return 0, fmt.Errorf("lua2interface: table has a gap at [%d]", i)
+			}
+		}
+	}
+	return max, nil
+}
+
+// tableToVariant converts a sequence-style Lua table into VT_ARRAY|VT_VARIANT
+// whose lower bound is 0.
+// The caller has to Clear() the result to free the SAFEARRAY.
+func tableToVariant(L *lua.LState, t *lua.LTable) (*ole.VARIANT, error) {
+	return tableToVariantLower(L, t, 0)
+}
+
+// tableToVariantLower converts a sequence-style Lua table into
+// VT_ARRAY|VT_VARIANT whose indices start from lower (1 for the servers
+// of VB6 and so on). t[1] is the element a(lower).
+// A table whose elements are all tables becomes a 2D SAFEARRAY,
+// so that t[i][j] is the element a(i,j) .
+// The array remains in v, so the caller destroys it.
+// The caller has to Clear() the result to free the SAFEARRAY.
+func tableToVariantLower(L *lua.LState, t *lua.LTable, lower int32) (*ole.VARIANT, error) {
+	n, err := sequenceLen(t)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]*lua.LTable, 0, n)
+	cols := -1
+	for i := 1; i <= n; i++ {
+		row, ok := t.RawGetInt(i).(*lua.LTable)
+		if !ok {
+			break
+		}
+		m, err := sequenceLen(row)
+		if err != nil {
+			return nil, err
+		}
+		if cols < 0 {
+			cols = m
+		} else if cols != m {
+			return nil, errors.New("lua2interface: rows of 2D table have different lengths")
+		}
+		rows = append(rows, row)
+	}
+	var sa *ole.SafeArray
+	if n > 0 && len(rows) == n {
+		sa, err = safeArrayCreate(ole.VT_VARIANT, []ole.SafeArrayBound{
+			{Elements: uint32(n), LowerBound: lower},
+			{Elements: uint32(cols), LowerBound: lower},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("lua2interface: SafeArrayCreate: %s", err.Error())
+		}
+		for i, row := range rows {
+			for j := 0; j < cols; j++ {
+				err = putLValue(L, sa, []int32{lower + int32(j), lower + int32(i)}, row.RawGetInt(j+1))
+				if err != nil {
+					safeArrayDestroy(sa)
+					return nil, err
+				}
+			}
+		}
+	} else {
+		sa, err = safeArrayCreate(ole.VT_VARIANT, []ole.SafeArrayBound{
+			{Elements: uint32(n), LowerBound: lower},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("lua2interface: SafeArrayCreate: %s", err.Error())
+		}
+		for i := 0; i < n; i++ {
+			err = putLValue(L, sa, []int32{lower + int32(i)}, t.RawGetInt(i+1))
+			if err != nil {
+				safeArrayDestroy(sa)
+				return nil, err
+			}
+		}
+	}
+	v := ole.NewVariant(ole.VT_ARRAY|ole.VT_VARIANT, int64(uintptr(unsafe.Pointer(sa))))
+	return &v, nil
+}
+
+func putLValue(L *lua.LState, sa *ole.SafeArray, indices []int32, value lua.LValue) error {
+	param, err := lvalue2interface(L, value)
+	if err != nil {
+		return err
+	}
+	elem, err := toVariant(param)
+	if err != nil {
+		return err
+	}
+	defer elem.Clear()
+	if err := safeArrayPutElement(sa, indices, unsafe.Pointer(elem)); err != nil {
+		return fmt.Errorf("lua2interface: SafeArrayPutElement: %s", err.Error())
+	}
+	return nil
+}
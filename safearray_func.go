@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+import (
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+func safeArrayCreate(vt ole.VT, bounds []ole.SafeArrayBound) (*ole.SafeArray, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func safeArrayDestroy(sa *ole.SafeArray) {}
+
+func safeArrayGetDim(sa *ole.SafeArray) int {
+	return 0
+}
+
+func safeArrayGetBounds(sa *ole.SafeArray, dim int) (int32, int32, error) {
+	return 0, 0, ole.NewError(ole.E_NOTIMPL)
+}
+
+func safeArrayGetElement(sa *ole.SafeArray, indices []int32, pv unsafe.Pointer) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
+
+func safeArrayPutElement(sa *ole.SafeArray, indices []int32, pv unsafe.Pointer) error {
+	return ole.NewError(ole.E_NOTIMPL)
+}
+
+func safeArrayAccessData(sa *ole.SafeArray) (unsafe.Pointer, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func safeArrayUnaccessData(sa *ole.SafeArray) {}
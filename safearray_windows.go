@@ -0,0 +1,104 @@
+package ole
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var (
+	modoleaut32 = syscall.NewLazyDLL("oleaut32.dll")
+
+	procSafeArrayCreate     = modoleaut32.NewProc("SafeArrayCreate")
+	procSafeArrayDestroy    = modoleaut32.NewProc("SafeArrayDestroy")
+	procSafeArrayGetDim     = modoleaut32.NewProc("SafeArrayGetDim")
+	procSafeArrayGetLBound  = modoleaut32.NewProc("SafeArrayGetLBound")
+	procSafeArrayGetUBound  = modoleaut32.NewProc("SafeArrayGetUBound")
+	procSafeArrayGetElement = modoleaut32.NewProc("SafeArrayGetElement")
+	procSafeArrayPutElement = modoleaut32.NewProc("SafeArrayPutElement")
+	procSafeArrayAccessData = modoleaut32.NewProc("SafeArrayAccessData")
+	procSafeArrayUnaccess   = modoleaut32.NewProc("SafeArrayUnaccessData")
+)
+
+// safeArrayCreate makes a SAFEARRAY. bounds[0] is the left-most dimension.
+func safeArrayCreate(vt ole.VT, bounds []ole.SafeArrayBound) (*ole.SafeArray, error) {
+	r, _, err := procSafeArrayCreate.Call(
+		uintptr(vt),
+		uintptr(len(bounds)),
+		uintptr(unsafe.Pointer(&bounds[0])))
+	if r == 0 {
+		return nil, err
+	}
+	return *(**ole.SafeArray)(unsafe.Pointer(&r)), nil
+}
+
+func safeArrayDestroy(sa *ole.SafeArray) {
+	procSafeArrayDestroy.Call(uintptr(unsafe.Pointer(sa)))
+}
+
+func safeArrayGetDim(sa *ole.SafeArray) int {
+	n, _, _ := procSafeArrayGetDim.Call(uintptr(unsafe.Pointer(sa)))
+	return int(n)
+}
+
+func safeArrayGetBounds(sa *ole.SafeArray, dim int) (lower int32, upper int32, err error) {
+	hr, _, _ := procSafeArrayGetLBound.Call(
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(dim),
+		uintptr(unsafe.Pointer(&lower)))
+	if hr != 0 {
+		return 0, 0, ole.NewError(hr)
+	}
+	hr, _, _ = procSafeArrayGetUBound.Call(
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(dim),
+		uintptr(unsafe.Pointer(&upper)))
+	if hr != 0 {
+		return 0, 0, ole.NewError(hr)
+	}
+	return lower, upper, nil
+}
+
+// safeArrayGetElement copies one element into pv.
+// indices[0] is the right-most dimension as SafeArrayGetElement requires.
+func safeArrayGetElement(sa *ole.SafeArray, indices []int32, pv unsafe.Pointer) error {
+	hr, _, _ := procSafeArrayGetElement.Call(
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(unsafe.Pointer(&indices[0])),
+		uintptr(pv))
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
+
+// safeArrayPutElement copies the value which pv points into the array.
+// indices[0] is the right-most dimension as SafeArrayPutElement requires.
+func safeArrayPutElement(sa *ole.SafeArray, indices []int32, pv unsafe.Pointer) error {
+	hr, _, _ := procSafeArrayPutElement.Call(
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(unsafe.Pointer(&indices[0])),
+		uintptr(pv))
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
+
+// safeArrayAccessData locks the array and returns the pointer to its elements.
+// The caller has to call safeArrayUnaccessData.
+func safeArrayAccessData(sa *ole.SafeArray) (unsafe.Pointer, error) {
+	var data unsafe.Pointer
+	hr, _, _ := procSafeArrayAccessData.Call(
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(unsafe.Pointer(&data)))
+	if hr != 0 {
+		return nil, ole.NewError(hr)
+	}
+	return data, nil
+}
+
+func safeArrayUnaccessData(sa *ole.SafeArray) {
+	procSafeArrayUnaccess.Call(uintptr(unsafe.Pointer(sa)))
+}
@@ -0,0 +1,93 @@
+package ole
+
+import (
+	"github.com/yuin/gopher-lua"
+)
+
+const scopeRegistryKey = "ole.scope"
+
+// scopeT is the objects created while the function of With runs.
+type scopeT struct {
+	capsules []*capsuleT
+	parent   *scopeT
+}
+
+func currentScope(L *lua.LState) *scopeT {
+	ud, ok := L.G.Registry.RawGetString(scopeRegistryKey).(*lua.LUserData)
+	if !ok {
+		return nil
+	}
+	s, _ := ud.Value.(*scopeT)
+	return s
+}
+
+func setScope(L *lua.LState, s *scopeT) {
+	if s == nil {
+		L.G.Registry.RawSetString(scopeRegistryKey, lua.LNil)
+		return
+	}
+	ud := L.NewUserData()
+	ud.Value = s
+	L.G.Registry.RawSetString(scopeRegistryKey, ud)
+}
+
+// track adds the object to the scope of With if it exists.
+func track(L *lua.LState, c *capsuleT) {
+	if s := currentScope(L); s != nil {
+		s.capsules = append(s.capsules, c)
+	}
+}
+
+// With calls the function with the object as `ole.With(OBJ,function(OBJ) ... end)`
+// and releases the object and the others created in the function on return
+// even on error, because __gc is not called until Lua's GC runs (or never)
+// and servers like Excel keep running while their objects are referred.
+// The objects returned by the function are not released.
+func With(L *lua.LState) int {
+	ud := L.CheckUserData(1)
+	obj, ok := ud.Value.(*capsuleT)
+	if !ok {
+		L.ArgError(1, "not an OLE object")
+	}
+	fn := L.CheckFunction(2)
+
+	parent := currentScope(L)
+	scope := &scopeT{parent: parent}
+	setScope(L, scope)
+
+	top := L.GetTop()
+	L.Push(fn)
+	L.Push(ud)
+	err := L.PCall(1, lua.MultRet, nil)
+	setScope(L, parent)
+
+	results := map[*capsuleT]bool{}
+	if err == nil {
+		for i := top + 1; i <= L.GetTop(); i++ {
+			if r, ok := L.Get(i).(*lua.LUserData); ok {
+				if c, ok := r.Value.(*capsuleT); ok {
+					results[c] = true
+				}
+			}
+		}
+	}
+	for _, c := range scope.capsules {
+		if results[c] {
+			if parent != nil {
+				parent.capsules = append(parent.capsules, c)
+			}
+			continue
+		}
+		c.release()
+	}
+	if !results[obj] {
+		obj.release()
+	}
+	if err != nil {
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			L.Error(apiErr.Object, 0)
+		}
+		L.RaiseError("%s", err.Error())
+	}
+	return L.GetTop() - top
+}
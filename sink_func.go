@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+import (
+	"github.com/go-ole/go-ole"
+)
+
+type connectionT struct{}
+
+func connect(disp *ole.IDispatch, event string, handler func(args []ole.VARIANT)) (*connectionT, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func (c *connectionT) disconnect() error {
+	return nil
+}
+
+func newCallback(handler func(args []ole.VARIANT, result *ole.VARIANT, excepInfo *excepInfoT) uintptr) *ole.IDispatch {
+	return nil
+}
@@ -0,0 +1,266 @@
+package ole
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+var iidIProvideClassInfo = ole.NewGUID("{B196B283-BAB4-101A-B69C-00AA00341D07}")
+
+const (
+	implTypeFlagDefault = 0x1
+	implTypeFlagSource  = 0x2
+)
+
+type iProvideClassInfoVtbl struct {
+	ole.IUnknownVtbl
+	GetClassInfo uintptr
+}
+
+// sinkT is the IDispatch implemented in Go which receives the events
+// or the calls of the callback objects.
+type sinkT struct {
+	lpVtbl *sinkVtbl
+	ref    int32
+	iid    ole.GUID
+	// anyName makes GetIDsOfNames return DISPID_VALUE for every name.
+	anyName bool
+	handler func(dispid int32, args []ole.VARIANT, result *ole.VARIANT, excepInfo *excepInfoT) uintptr
+}
+
+type sinkVtbl struct {
+	QueryInterface   uintptr
+	AddRef           uintptr
+	Release          uintptr
+	GetTypeInfoCount uintptr
+	GetTypeInfo      uintptr
+	GetIDsOfNames    uintptr
+	Invoke           uintptr
+}
+
+var (
+	sinkVtblOnce     sync.Once
+	sinkVtblInstance *sinkVtbl
+
+	// liveSinks keeps the sinks referred by COM from Go's GC.
+	liveSinks = map[*sinkT]struct{}{}
+)
+
+func newSinkVtbl() *sinkVtbl {
+	sinkVtblOnce.Do(func() {
+		sinkVtblInstance = &sinkVtbl{
+			QueryInterface:   syscall.NewCallback(sinkQueryInterface),
+			AddRef:           syscall.NewCallback(sinkAddRef),
+			Release:          syscall.NewCallback(sinkRelease),
+			GetTypeInfoCount: syscall.NewCallback(sinkGetTypeInfoCount),
+			GetTypeInfo:      syscall.NewCallback(sinkNotImpl4),
+			GetIDsOfNames:    syscall.NewCallback(sinkGetIDsOfNames),
+			Invoke:           syscall.NewCallback(sinkInvoke),
+		}
+	})
+	return sinkVtblInstance
+}
+
+func sinkQueryInterface(this *sinkT, iid *ole.GUID, ppv *uintptr) uintptr {
+	if ole.IsEqualGUID(iid, ole.IID_IUnknown) ||
+		ole.IsEqualGUID(iid, ole.IID_IDispatch) ||
+		ole.IsEqualGUID(iid, &this.iid) {
+		sinkAddRef(this)
+		*ppv = uintptr(unsafe.Pointer(this))
+		return ole.S_OK
+	}
+	*ppv = 0
+	return ole.E_NOINTERFACE
+}
+
+func sinkAddRef(this *sinkT) uintptr {
+	this.ref++
+	return uintptr(this.ref)
+}
+
+func sinkRelease(this *sinkT) uintptr {
+	this.ref--
+	if this.ref <= 0 {
+		delete(liveSinks, this)
+		return 0
+	}
+	return uintptr(this.ref)
+}
+
+func sinkGetTypeInfoCount(this *sinkT, pctinfo *uint32) uintptr {
+	*pctinfo = 0
+	return ole.S_OK
+}
+
+func sinkNotImpl4(this, a, b, c uintptr) uintptr {
+	return ole.E_NOTIMPL
+}
+
+func sinkGetIDsOfNames(this *sinkT, riid, names, count, lcid uintptr, ids *int32) uintptr {
+	if !this.anyName {
+		return ole.E_NOTIMPL
+	}
+	dispids := (*[1 << 16]int32)(unsafe.Pointer(ids))[:count:count]
+	for i := range dispids {
+		dispids[i] = ole.DISPID_VALUE
+	}
+	return ole.S_OK
+}
+
+func sinkInvoke(this *sinkT, dispid int32, riid, lcid, flags uintptr, params *dispParams, result *ole.VARIANT, excepInfo *excepInfoT, argErr uintptr) uintptr {
+	var args []ole.VARIANT
+	if params != nil && params.cArgs > 0 {
+		// DISPPARAMS has the arguments in reverse order.
+		rgvarg := *(*unsafe.Pointer)(unsafe.Pointer(&params.rgvarg))
+		vargs := (*[1 << 16]ole.VARIANT)(rgvarg)[:params.cArgs:params.cArgs]
+		args = make([]ole.VARIANT, len(vargs))
+		for i := range vargs {
+			args[len(vargs)-1-i] = vargs[i]
+		}
+	}
+	return this.handler(dispid, args, result, excepInfo)
+}
+
+// sourceInterface returns the default source interface of the object's class.
+func sourceInterface(disp *ole.IDispatch) (*ole.ITypeInfo, error) {
+	unknown, err := disp.QueryInterface(iidIProvideClassInfo)
+	if err != nil {
+		return nil, err
+	}
+	defer unknown.Release()
+
+	var classInfo *ole.ITypeInfo
+	vtbl := (*iProvideClassInfoVtbl)(unsafe.Pointer(unknown.RawVTable))
+	hr, _, _ := syscall.Syscall(vtbl.GetClassInfo, 2,
+		uintptr(unsafe.Pointer(unknown)), uintptr(unsafe.Pointer(&classInfo)), 0)
+	if hr != 0 {
+		return nil, ole.NewError(hr)
+	}
+	defer classInfo.Release()
+
+	attr, err := classInfo.GetTypeAttr()
+	if err != nil {
+		return nil, err
+	}
+	count := int(attr.CImplTypes)
+	syscall.Syscall(classInfo.VTable().ReleaseTypeAttr, 2,
+		uintptr(unsafe.Pointer(classInfo)), uintptr(unsafe.Pointer(attr)), 0)
+
+	for i := 0; i < count; i++ {
+		var flags int32
+		hr, _, _ := syscall.Syscall(classInfo.VTable().GetImplTypeFlags, 3,
+			uintptr(unsafe.Pointer(classInfo)), uintptr(i), uintptr(unsafe.Pointer(&flags)))
+		if hr != 0 || flags&(implTypeFlagDefault|implTypeFlagSource) != implTypeFlagDefault|implTypeFlagSource {
+			continue
+		}
+		var href uint32
+		hr, _, _ = syscall.Syscall(classInfo.VTable().GetRefTypeOfImplType, 3,
+			uintptr(unsafe.Pointer(classInfo)), uintptr(i), uintptr(unsafe.Pointer(&href)))
+		if hr != 0 {
+			return nil, ole.NewError(hr)
+		}
+		var source *ole.ITypeInfo
+		hr, _, _ = syscall.Syscall(classInfo.VTable().GetRefTypeInfo, 3,
+			uintptr(unsafe.Pointer(classInfo)), uintptr(href), uintptr(unsafe.Pointer(&source)))
+		if hr != 0 {
+			return nil, ole.NewError(hr)
+		}
+		return source, nil
+	}
+	return nil, errors.New("the object has no default source interface")
+}
+
+// connectionT is the sink advised to the connection point.
+type connectionT struct {
+	point  *ole.IConnectionPoint
+	cookie uint32
+}
+
+// connect advises the sink which calls the handler for the event.
+func connect(disp *ole.IDispatch, event string, handler func(args []ole.VARIANT)) (*connectionT, error) {
+	source, err := sourceInterface(disp)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Release()
+
+	var dispid int32
+	name := syscall.StringToUTF16Ptr(event)
+	hr, _, _ := syscall.Syscall6(source.VTable().GetIDsOfNames, 4,
+		uintptr(unsafe.Pointer(source)),
+		uintptr(unsafe.Pointer(&name)),
+		1,
+		uintptr(unsafe.Pointer(&dispid)),
+		0, 0)
+	if hr != 0 {
+		return nil, ole.NewError(hr)
+	}
+	attr, err := source.GetTypeAttr()
+	if err != nil {
+		return nil, err
+	}
+	iid := attr.Guid
+	syscall.Syscall(source.VTable().ReleaseTypeAttr, 2,
+		uintptr(unsafe.Pointer(source)), uintptr(unsafe.Pointer(attr)), 0)
+
+	unknown, err := disp.QueryInterface(ole.IID_IConnectionPointContainer)
+	if err != nil {
+		return nil, err
+	}
+	defer unknown.Release()
+	container := (*ole.IConnectionPointContainer)(unsafe.Pointer(unknown))
+	var point *ole.IConnectionPoint
+	if err := container.FindConnectionPoint(&iid, &point); err != nil {
+		return nil, err
+	}
+
+	sink := &sinkT{
+		lpVtbl: newSinkVtbl(),
+		iid:    iid,
+		handler: func(id int32, args []ole.VARIANT, _ *ole.VARIANT, _ *excepInfoT) uintptr {
+			if id == dispid {
+				handler(args)
+			}
+			return ole.S_OK
+		},
+	}
+	liveSinks[sink] = struct{}{}
+	sinkAddRef(sink)
+	defer sinkRelease(sink)
+	cookie, err := point.Advise((*ole.IUnknown)(unsafe.Pointer(sink)))
+	if err != nil {
+		point.Release()
+		return nil, err
+	}
+	return &connectionT{point: point, cookie: cookie}, nil
+}
+
+func (c *connectionT) disconnect() error {
+	if c.point == nil {
+		return nil
+	}
+	err := c.point.Unadvise(c.cookie)
+	c.point.Release()
+	c.point = nil
+	return err
+}
+
+// newCallback returns the IDispatch whose members are all DISPID_VALUE
+// and call the handler. The caller owns the reference.
+func newCallback(handler func(args []ole.VARIANT, result *ole.VARIANT, excepInfo *excepInfoT) uintptr) *ole.IDispatch {
+	sink := &sinkT{
+		lpVtbl:  newSinkVtbl(),
+		iid:     *ole.IID_IDispatch,
+		anyName: true,
+		handler: func(_ int32, args []ole.VARIANT, result *ole.VARIANT, excepInfo *excepInfoT) uintptr {
+			return handler(args, result, excepInfo)
+		},
+	}
+	liveSinks[sink] = struct{}{}
+	sinkAddRef(sink)
+	return (*ole.IDispatch)(unsafe.Pointer(sink))
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+func currentThreadID() uint32 {
+	return 0
+}
@@ -0,0 +1,16 @@
+package ole
+
+import (
+	"syscall"
+)
+
+var (
+	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procGetCurrentThreadId = modkernel32.NewProc("GetCurrentThreadId")
+)
+
+func currentThreadID() uint32 {
+	id, _, _ := procGetCurrentThreadId.Call()
+	return uint32(id)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package ole
+
+import (
+	"github.com/go-ole/go-ole"
+)
+
+func typeName(disp *ole.IDispatch) (string, error) {
+	return "", ole.NewError(ole.E_NOTIMPL)
+}
+
+func typeMembers(disp *ole.IDispatch) ([]memberInfo, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
+
+func isDispatchable(disp *ole.IDispatch, iid *ole.GUID) (bool, error) {
+	return false, ole.NewError(ole.E_NOTIMPL)
+}
+
+func classID(disp *ole.IDispatch) (*ole.GUID, error) {
+	return nil, ole.NewError(ole.E_NOTIMPL)
+}
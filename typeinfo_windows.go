@@ -0,0 +1,266 @@
+package ole
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+const (
+	invokeFunc           = 1
+	invokePropertyGet    = 2
+	invokePropertyPut    = 4
+	invokePropertyPutRef = 8
+
+	funcFlagRestricted = 0x1
+	varFlagRestricted  = 0x80
+)
+
+type typeDesc struct {
+	lptdesc uintptr
+	vt      uint16
+}
+
+type paramDesc struct {
+	pparamdescex uintptr
+	wParamFlags  uint16
+}
+
+type elemDesc struct {
+	tdesc     typeDesc
+	paramdesc paramDesc
+}
+
+// funcDesc is FUNCDESC
+type funcDesc struct {
+	memid             int32
+	lprgscode         uintptr
+	lprgelemdescParam uintptr
+	funckind          int32
+	invkind           int32
+	callconv          int32
+	cParams           int16
+	cParamsOpt        int16
+	oVft              int16
+	cScodes           int16
+	elemdescFunc      elemDesc
+	wFuncFlags        uint16
+}
+
+// varDesc is VARDESC
+type varDesc struct {
+	memid       int32
+	lpstrSchema uintptr
+	oInst       uintptr
+	elemdescVar elemDesc
+	wVarFlags   uint16
+	varkind     int32
+}
+
+func getDocumentation(tinfo *ole.ITypeInfo, memid int32) (string, error) {
+	var name *uint16
+	hr, _, _ := syscall.Syscall6(
+		tinfo.VTable().GetDocumentation,
+		6,
+		uintptr(unsafe.Pointer(tinfo)),
+		uintptr(memid),
+		uintptr(unsafe.Pointer(&name)),
+		0,
+		0,
+		0)
+	if hr != 0 {
+		return "", ole.NewError(hr)
+	}
+	defer ole.SysFreeString((*int16)(unsafe.Pointer(name)))
+	return ole.BstrToString(name), nil
+}
+
+// typeName returns the name of the interface by ITypeInfo.GetDocumentation
+func typeName(disp *ole.IDispatch) (string, error) {
+	tinfo, err := disp.GetTypeInfo()
+	if err != nil {
+		return "", err
+	}
+	defer tinfo.Release()
+
+	return getDocumentation(tinfo, -1) // MEMBERID_NIL
+}
+
+func invokeKindName(invkind int32) string {
+	switch invkind {
+	case invokePropertyGet:
+		return "propget"
+	case invokePropertyPut:
+		return "propput"
+	case invokePropertyPutRef:
+		return "propputref"
+	default:
+		return "method"
+	}
+}
+
+// typeMembers lists the functions and the variables in the type information
+// except for the restricted ones (for example, IDispatch's own methods).
+func typeMembers(disp *ole.IDispatch) ([]memberInfo, error) {
+	tinfo, err := disp.GetTypeInfo()
+	if err != nil {
+		return nil, err
+	}
+	defer tinfo.Release()
+
+	attr, err := tinfo.GetTypeAttr()
+	if err != nil {
+		return nil, err
+	}
+	cFuncs, cVars := int(attr.CFuncs), int(attr.CVars)
+	syscall.Syscall(tinfo.VTable().ReleaseTypeAttr, 2,
+		uintptr(unsafe.Pointer(tinfo)), uintptr(unsafe.Pointer(attr)), 0)
+
+	members := make([]memberInfo, 0, cFuncs+cVars)
+	for i := 0; i < cFuncs; i++ {
+		var desc *funcDesc
+		hr, _, _ := syscall.Syscall(tinfo.VTable().GetFuncDesc, 3,
+			uintptr(unsafe.Pointer(tinfo)), uintptr(i), uintptr(unsafe.Pointer(&desc)))
+		if hr != 0 {
+			return nil, ole.NewError(hr)
+		}
+		memid, invkind, flags := desc.memid, desc.invkind, desc.wFuncFlags
+		syscall.Syscall(tinfo.VTable().ReleaseFuncDesc, 2,
+			uintptr(unsafe.Pointer(tinfo)), uintptr(unsafe.Pointer(desc)), 0)
+		if flags&funcFlagRestricted != 0 {
+			continue
+		}
+		name, err := getDocumentation(tinfo, memid)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, memberInfo{Name: name, Kind: invokeKindName(invkind), DispID: memid})
+	}
+	for i := 0; i < cVars; i++ {
+		var desc *varDesc
+		hr, _, _ := syscall.Syscall(tinfo.VTable().GetVarDesc, 3,
+			uintptr(unsafe.Pointer(tinfo)), uintptr(i), uintptr(unsafe.Pointer(&desc)))
+		if hr != 0 {
+			return nil, ole.NewError(hr)
+		}
+		memid, flags := desc.memid, desc.wVarFlags
+		syscall.Syscall(tinfo.VTable().ReleaseVarDesc, 2,
+			uintptr(unsafe.Pointer(tinfo)), uintptr(unsafe.Pointer(desc)), 0)
+		if flags&varFlagRestricted != 0 {
+			continue
+		}
+		name, err := getDocumentation(tinfo, memid)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, memberInfo{Name: name, Kind: "property", DispID: memid})
+	}
+	return members, nil
+}
+
+const (
+	typeFlagDual         = 0x40
+	typeFlagDispatchable = 0x1000
+	typeKindDispatch     = 4
+)
+
+type iTypeLibVtbl struct {
+	ole.IUnknownVtbl
+	GetTypeInfoCount  uintptr
+	GetTypeInfo       uintptr
+	GetTypeInfoType   uintptr
+	GetTypeInfoOfGuid uintptr
+}
+
+// isDispatchable reports whether the interface of iid derives from IDispatch
+// by the type library of the object, so that the pointer which
+// QueryInterface returns for it can be used as IDispatch.
+func isDispatchable(disp *ole.IDispatch, iid *ole.GUID) (bool, error) {
+	if ole.IsEqualGUID(iid, ole.IID_IDispatch) {
+		return true, nil
+	}
+	tinfo, err := disp.GetTypeInfo()
+	if err != nil {
+		return false, err
+	}
+	defer tinfo.Release()
+
+	var tlib *ole.IUnknown
+	var index uint32
+	hr, _, _ := syscall.Syscall(tinfo.VTable().GetContainingTypeLib, 3,
+		uintptr(unsafe.Pointer(tinfo)), uintptr(unsafe.Pointer(&tlib)), uintptr(unsafe.Pointer(&index)))
+	if hr != 0 {
+		return false, ole.NewError(hr)
+	}
+	defer tlib.Release()
+
+	var target *ole.ITypeInfo
+	vtbl := (*iTypeLibVtbl)(unsafe.Pointer(tlib.RawVTable))
+	hr, _, _ = syscall.Syscall(vtbl.GetTypeInfoOfGuid, 3,
+		uintptr(unsafe.Pointer(tlib)), uintptr(unsafe.Pointer(iid)), uintptr(unsafe.Pointer(&target)))
+	if hr != 0 {
+		return false, ole.NewError(hr)
+	}
+	defer target.Release()
+
+	attr, err := target.GetTypeAttr()
+	if err != nil {
+		return false, err
+	}
+	ok := attr.Typekind == typeKindDispatch ||
+		attr.WTypeFlags&(typeFlagDual|typeFlagDispatchable) != 0
+	syscall.Syscall(target.VTable().ReleaseTypeAttr, 2,
+		uintptr(unsafe.Pointer(target)), uintptr(unsafe.Pointer(attr)), 0)
+	return ok, nil
+}
+
+var iidIPersist = ole.NewGUID("{0000010C-0000-0000-C000-000000000046}")
+
+type iPersistVtbl struct {
+	ole.IUnknownVtbl
+	GetClassID uintptr
+}
+
+const typeKindCoClass = 5
+
+// classID returns the CLSID of the object by IPersist.GetClassID,
+// or by the coclass of IProvideClassInfo.
+func classID(disp *ole.IDispatch) (*ole.GUID, error) {
+	if unknown, err := disp.QueryInterface(iidIPersist); err == nil {
+		defer unknown.Release()
+		var clsid ole.GUID
+		vtbl := (*iPersistVtbl)(unsafe.Pointer(unknown.RawVTable))
+		hr, _, _ := syscall.Syscall(vtbl.GetClassID, 2,
+			uintptr(unsafe.Pointer(unknown)), uintptr(unsafe.Pointer(&clsid)), 0)
+		if hr == 0 {
+			return &clsid, nil
+		}
+	}
+	unknown, err := disp.QueryInterface(iidIProvideClassInfo)
+	if err != nil {
+		return nil, err
+	}
+	defer unknown.Release()
+
+	var classInfo *ole.ITypeInfo
+	vtbl := (*iProvideClassInfoVtbl)(unsafe.Pointer(unknown.RawVTable))
+	hr, _, _ := syscall.Syscall(vtbl.GetClassInfo, 2,
+		uintptr(unsafe.Pointer(unknown)), uintptr(unsafe.Pointer(&classInfo)), 0)
+	if hr != 0 {
+		return nil, ole.NewError(hr)
+	}
+	defer classInfo.Release()
+
+	attr, err := classInfo.GetTypeAttr()
+	if err != nil {
+		return nil, err
+	}
+	clsid, kind := attr.Guid, attr.Typekind
+	syscall.Syscall(classInfo.VTable().ReleaseTypeAttr, 2,
+		uintptr(unsafe.Pointer(classInfo)), uintptr(unsafe.Pointer(attr)), 0)
+	if kind != typeKindCoClass {
+		return nil, ole.NewError(ole.E_NOINTERFACE)
+	}
+	return &clsid, nil
+}